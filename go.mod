@@ -4,15 +4,20 @@ go 1.24
 
 require (
 	github.com/ProtonMail/go-mbox v1.1.0
+	github.com/emersion/go-ical v0.0.0-20250329121855-f41e73efc392
+	github.com/emersion/go-msgauth v0.7.0
+	github.com/expr-lang/expr v1.17.8
 	github.com/google/go-cmp v0.6.0
 	github.com/jessevdk/go-flags v1.6.1
 	github.com/rorycl/letters v0.1.2
+	github.com/teambition/rrule-go v1.8.2
+	golang.org/x/net v0.39.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/rorycl/base64toraw v0.0.1 // indirect
-	golang.org/x/net v0.39.0 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
 )
@@ -3,35 +3,38 @@ mboxfilterer
 
 This programme outputs a concise csv summarising unique emails in one or more mbox files which pass filtering.
 
-These filters currently are:
-newFilterIP           : ensuring the sender is from a specified ip range
-newFilterByReportDate : within the report date
-newFilterByHoliday    : while not on holiday
-newFilterBySender     : from specified senders only
-newFilterByID         : a unique message id
+The filtering is done by the mboxfilterer/pkg/filter package, which
+documents the filters.
 
 RCL 20 December 2024
 */
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
+
+	"mboxfilterer/pkg/filter"
 )
 
 // Options are flags options
 type Options struct {
 	// Verbose  bool `short:"v" long:"verbose"  description:"show verbose output\nthis presently does not do much"`
-	Config string `short:"c" long:"config" description:"yaml configuration file (required)" required:"yes"`
-	Output string `short:"o" long:"output" description:"optional output csv file"`
-	Args   struct {
+	Config   string `short:"c" long:"config" description:"yaml configuration file (required)" required:"yes"`
+	Output   string `short:"o" long:"output" description:"optional output csv file"`
+	Progress string `short:"p" long:"progress" description:"progress on stderr, shown automatically on a terminal, or as json lines" choice:"auto" choice:"off" choice:"text" choice:"json" default:"auto"`
+	Stats    string `short:"s" long:"stats" description:"stats output, or a json manifest of outputs and stats" choice:"summary" choice:"table" choice:"json" choice:"manifest" default:"summary"`
+	Args     struct {
 		MboxFiles []string `description:"one or more mbox files to process"`
 	} `positional-args:"yes" required:"yes"`
 }
@@ -59,6 +62,105 @@ func makeOutputFile(s string) (*os.File, error) {
 	return os.Create(fileName)
 }
 
+// reportOutputs are the sinks writing the report and the optional
+// reports alongside it, together with their files
+type reportOutputs struct {
+	sink    filter.Sink
+	grouped *filter.GroupedCSVSink // optional grouped reports
+	files   []*os.File
+}
+
+// paths returns the paths of the output files, including any grouped
+// reports and their index
+func (r *reportOutputs) paths() []string {
+	paths := []string{}
+	for _, f := range r.files {
+		paths = append(paths, f.Name())
+	}
+	if r.grouped != nil {
+		for _, g := range r.grouped.Groups() {
+			paths = append(paths, g.Path)
+		}
+		paths = append(paths, r.grouped.IndexPath())
+	}
+	return paths
+}
+
+// close closes the files of the outputs
+func (r *reportOutputs) close() error {
+	var errs []error
+	for _, f := range r.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// makeReportOutputs makes the sinks writing the report to wfile, with a
+// subject max length of 10 chars, and writing alongside it any report
+// for each group of emails, the attachment inventory and the index of
+// exported attachments to the store
+func makeReportOutputs(config filter.Config, pipeline *filter.Pipeline, wfile *os.File) (*reportOutputs, error) {
+	r := reportOutputs{files: []*os.File{wfile}}
+	sinks := []filter.Sink{filter.NewCSVSink(csv.NewWriter(wfile), 10, config.Location, pipeline.Columns...)}
+	prefix := strings.TrimSuffix(wfile.Name(), ".csv") + "-"
+
+	if config.GroupBy != "" {
+		sink, err := filter.NewGroupedCSVSink(config.GroupBy, prefix, 10, config.Location, pipeline.Columns...)
+		if err != nil {
+			return nil, err
+		}
+		r.grouped = sink
+		sinks = append(sinks, sink)
+	}
+	if config.AttachmentInventory {
+		afile, err := makeOutputFile(prefix + "attachments.csv")
+		if err != nil {
+			return nil, err
+		}
+		r.files = append(r.files, afile)
+		sinks = append(sinks, filter.NewAttachmentInventorySink(csv.NewWriter(afile), config.Location))
+	}
+	if store := pipeline.AttachmentStore; store != nil {
+		ifile, err := makeOutputFile(store.IndexPath())
+		if err != nil {
+			return nil, err
+		}
+		r.files = append(r.files, ifile)
+		sinks = append(sinks, filter.NewAttachmentIndexSink(csv.NewWriter(ifile)))
+	}
+	r.sink = filter.NewMultiSink(sinks...)
+	return &r, nil
+}
+
+// isTerminal reports if f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// manifest describes the output files of processing, with its stats
+type manifest struct {
+	Report  string                 `json:"report"`
+	Outputs []string               `json:"outputs"`
+	Stats   filter.ProcessingStats `json:"stats"`
+}
+
+// writeManifest writes a manifest of the outputs as json to path
+func writeManifest(path string, outputs *reportOutputs, stats filter.ProcessingStats) error {
+	mfile, err := makeOutputFile(path)
+	if err != nil {
+		return err
+	}
+	defer mfile.Close()
+	m := manifest{Report: outputs.files[0].Name(), Outputs: outputs.paths(), Stats: stats}
+	enc := json.NewEncoder(mfile)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return fmt.Errorf("manifest writing error, %w", err)
+	}
+	return mfile.Close()
+}
+
 func main() {
 
 	// process arguments
@@ -83,7 +185,7 @@ func main() {
 		fmt.Printf("could not load file: %s", err)
 		os.Exit(1)
 	}
-	config, err := LoadYaml(filer)
+	config, err := filter.LoadYaml(filer)
 	if err != nil {
 		fmt.Println("yaml loading error", err)
 		os.Exit(1)
@@ -95,43 +197,94 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	writer := csv.NewWriter(wfile)
-
-	// init Emails container
-	emails := NewEmails()
-
-	// init Filters
-	filters := NewFilters(
-		newFilterIP("ip invalid", config.ReceivedIPFragment),
-		newFilterByReportDate("outside daterange", config.ReportStart, config.ReportEnd),
-		newFilterByHoliday("on holiday", config.Holidays),
-		newFilterBySender("invalid sender", config.ValidSenderRegexp),
-		newFilterByID("duplicate id"),
-	)
-
-	// process files
-	emailChan, errorChan := process(options.Args.MboxFiles, filters)
-
-	// drain the error chan, exiting on first error
-	go func() {
-		select {
-		case err := <-errorChan:
-			if err != nil {
-				fmt.Println(err)
-				fmt.Println("exiting...")
-				os.Exit(1)
-			}
-		}
-	}()
 
-	// add emails
-	for e := range emailChan {
-		emails.Add(e)
+	// init the pipeline of filters for the mbox files
+	pipeline, err := filter.NewPipeline(config, filter.NewMboxFiles(options.Args.MboxFiles...)...)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// show progress on stderr, by default only if it is a terminal
+	format := options.Progress
+	if format == "auto" {
+		format = "off"
+		if isTerminal(os.Stderr) {
+			format = "text"
+		}
+	}
+	var progress *filter.Progress
+	if format != "off" {
+		progress, err = filter.NewProgress(os.Stderr, format, 0, pipeline.Filters, pipeline.Sources...)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		progress.Start()
 	}
 
-	// write out emails with a subject max length of 10 chars
-	emails.Write(writer, 10)
+	// process files, exiting on first error, writing the reports either
+	// as emails are processed or once they are all collected, then stop
+	// any exec filter helpers
+	var outputs *reportOutputs
+	if config.Streaming {
+		outputs, err = makeReportOutputs(config, pipeline, wfile)
+		if err == nil {
+			var sink filter.Sink = outputs.sink
+			if config.StreamSorted {
+				sink = filter.NewSortingSink(sink, config.StreamMemoryLimit, config.StreamTempDir)
+			}
+			err = pipeline.Stream(context.Background(), sink)
+		}
+	} else {
+		var emails filter.Emails
+		emails, err = pipeline.Collect(context.Background())
+		if err == nil {
+			// the report columns are only known once threads are made
+			outputs, err = makeReportOutputs(config, pipeline, wfile)
+		}
+		if err == nil {
+			emails.SortByDate()
+			err = emails.WriteSink(outputs.sink)
+		}
+	}
+	if progress != nil {
+		progress.Stop()
+	}
+	if cerr := pipeline.Close(); cerr != nil {
+		fmt.Println(cerr)
+	}
+	if outputs != nil {
+		if cerr := outputs.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("exiting...")
+		os.Exit(1)
+	}
+	if g := outputs.grouped; g != nil {
+		fmt.Printf("%d reports by %s indexed in %s\n", len(g.Groups()), config.GroupBy, g.IndexPath())
+	}
 
 	// show stats
-	fmt.Println(filters.Stats())
+	switch options.Stats {
+	case "table":
+		err = pipeline.Filters.ProcessingStats().WriteTable(os.Stdout, config.Location)
+	case "json":
+		err = pipeline.Filters.ProcessingStats().WriteJSON(os.Stdout)
+	case "manifest":
+		path := strings.TrimSuffix(outputs.files[0].Name(), ".csv") + "-manifest.json"
+		err = writeManifest(path, outputs, pipeline.Filters.ProcessingStats())
+		if err == nil {
+			fmt.Printf("manifest written to %s\n", path)
+		}
+	default:
+		fmt.Println(pipeline.Filters.Stats())
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
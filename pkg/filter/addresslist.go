@@ -0,0 +1,173 @@
+package filter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// AddressList is a set of email addresses, domains and wildcard
+// patterns loaded from one or more text or csv files, compiled for
+// efficient case-insensitive lookup. Each entry may be:
+//
+//	alice@example.com    an exact address
+//	example.com          every address at a domain (also "@example.com")
+//	*@*.example.com      a wildcard pattern using "*" and "?"
+//
+// Addresses and domains are normalised by folding to lower case,
+// converting international domain names to their ASCII (punycode) form
+// and removing any "+tag" plus-addressing suffix from the local part.
+type AddressList struct {
+	addresses map[string]struct{}
+	domains   map[string]struct{}
+	patterns  []string
+	wildcards *regexp.Regexp // compiled from patterns
+}
+
+// NewAddressList makes an empty AddressList
+func NewAddressList() *AddressList {
+	return &AddressList{
+		addresses: map[string]struct{}{},
+		domains:   map[string]struct{}{},
+	}
+}
+
+// normaliseDomain folds a domain to lower case in its ASCII form
+func normaliseDomain(d string) string {
+	d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+	if ascii, err := idna.ToASCII(d); err == nil {
+		return ascii
+	}
+	return d
+}
+
+// normaliseAddress normalises an email address for comparison
+func normaliseAddress(a string) string {
+	local, domain, ok := strings.Cut(strings.TrimSpace(a), "@")
+	if !ok {
+		return strings.ToLower(local)
+	}
+	local, _, _ = strings.Cut(strings.ToLower(local), "+")
+	return local + "@" + normaliseDomain(domain)
+}
+
+// Add adds an address, domain or wildcard pattern entry to the list
+func (l *AddressList) Add(entry string) {
+	l.add(entry)
+	l.compile()
+}
+
+// add adds an entry to the list without compiling wildcard patterns
+func (l *AddressList) add(entry string) {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case entry == "":
+		return
+	case strings.ContainsAny(entry, "*?"):
+		l.patterns = append(l.patterns, strings.ToLower(entry))
+	case strings.HasPrefix(entry, "@"):
+		l.domains[normaliseDomain(entry[1:])] = struct{}{}
+	case strings.Contains(entry, "@"):
+		l.addresses[normaliseAddress(entry)] = struct{}{}
+	default:
+		l.domains[normaliseDomain(entry)] = struct{}{}
+	}
+}
+
+// compile compiles the wildcard patterns into a single regular
+// expression
+func (l *AddressList) compile() {
+	if len(l.patterns) == 0 {
+		return
+	}
+	alternates := []string{}
+	for _, p := range l.patterns {
+		q := regexp.QuoteMeta(p)
+		q = strings.ReplaceAll(q, `\*`, `.*`)
+		q = strings.ReplaceAll(q, `\?`, `.`)
+		alternates = append(alternates, q)
+	}
+	l.wildcards = regexp.MustCompile(`^(?:` + strings.Join(alternates, "|") + `)$`)
+}
+
+// Len returns the number of entries in the list
+func (l *AddressList) Len() int {
+	return len(l.addresses) + len(l.domains) + len(l.patterns)
+}
+
+// Contains reports if the address is in the list. Contains is safe for
+// concurrent use.
+func (l *AddressList) Contains(address string) bool {
+	normalised := normaliseAddress(address)
+	if _, ok := l.addresses[normalised]; ok {
+		return true
+	}
+	if _, domain, ok := strings.Cut(normalised, "@"); ok {
+		if _, ok := l.domains[domain]; ok {
+			return true
+		}
+	}
+	if l.wildcards == nil {
+		return false
+	}
+	return l.wildcards.MatchString(normalised) || l.wildcards.MatchString(strings.ToLower(address))
+}
+
+// Load reads entries from a reader. Csv entries are read from the first
+// column of each record. Blank lines and lines starting with "#" are
+// ignored.
+func (l *AddressList) Load(r io.Reader, isCSV bool) error {
+	if isCSV {
+		reader := csv.NewReader(r)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			l.add(record[0])
+		}
+	} else {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			l.add(line)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	l.compile()
+	return nil
+}
+
+// LoadFiles loads entries from text or csv files, determining their
+// type by the file suffix.
+func (l *AddressList) LoadFiles(files ...string) error {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = l.Load(f, strings.EqualFold(filepath.Ext(file), ".csv"))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("address list %s error, %w", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"testing"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestNormaliseAddress(t *testing.T) {
+	tests := []struct {
+		address, want string
+	}{
+		{"Bob@Example.COM", "bob@example.com"},
+		{"bob+invoices@example.com", "bob@example.com"},
+		{"anna@Bücher.example", "anna@xn--bcher-kva.example"},
+		{"anna@xn--bcher-kva.example.", "anna@xn--bcher-kva.example"},
+		{"nodomain", "nodomain"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := normaliseAddress(tt.address); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressList(t *testing.T) {
+	l := NewAddressList()
+	if err := l.LoadFiles("testdata/custodians.txt", "testdata/excluded.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Len(), 7; got != want {
+		t.Errorf("got %d want %d entries", got, want)
+	}
+	for i, tt := range []struct {
+		address string
+		ok      bool
+	}{
+		{"robertosmith@smythersbrown.net", true},
+		{"ROBERTOSMITH+case@smythersbrown.net", true},
+		{"savimbi@smythersbrown.net", true},
+		{"bob@smythersbrown.net", false},
+		{"anyone@codata.ltd", true},
+		{"anyone@sub.codata.ltd", false},
+		{"anna@xn--bcher-kva.example", true},
+		{"isadoraxclhome@gmail.com", true},
+		{"izasmythersbrown@gmail.com", false},
+		{"noreply@smythersbrown.net", true},
+		{"news@eu.mailer.smythersbrown.net", true},
+	} {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := l.Contains(tt.address); got != tt.ok {
+				t.Errorf("%s got %t want %t", tt.address, got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSenderFilterLists(t *testing.T) {
+	allow := NewAddressList()
+	allow.Add("smythersbrown.net")
+	deny := NewAddressList()
+	deny.Add("noreply@smythersbrown.net")
+
+	for i, tt := range []struct {
+		allow, deny *AddressList
+		address     string
+		ok          bool
+	}{
+		{allow, deny, "bob@smythersbrown.net", true},
+		{allow, deny, "noreply+x@smythersbrown.net", false},
+		{allow, deny, "bob@other.org", false},
+		{nil, deny, "bob@other.org", true},
+		{nil, deny, "noreply@smythersbrown.net", false},
+	} {
+		nf := newFilterBySender("sender filter", nil, tt.allow, tt.deny)
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.From = []*mail.Address{{Address: tt.address}}
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("for %s got %t want %t", tt.address, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package filter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rorycl/letters/email"
+)
+
+// attachmentCountColumn and attachmentNamesColumn are the optional
+// report columns showing the number and names of the attachments of an
+// email
+const (
+	attachmentCountColumn = "attachments"
+	attachmentNamesColumn = "attachment names"
+)
+
+// attachmentInventoryHeader is the header of the attachment inventory
+// csv, which has a row for each attachment
+var attachmentInventoryHeader = []string{"date", "from", "source", "id", "filename", "content type", "disposition", "size", "sha256"}
+
+// Attachment describes an inline or attached file of an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Disposition string
+	Size        int64
+	SHA256      string // hex encoded
+	Path        string // path in an AttachmentStore, if exported
+	tempFile    string // temporary file awaiting commit to an AttachmentStore
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newAttachment reads an email file, recording its size and sha256 sum
+// without keeping its contents, unless a store is provided, in which
+// case the contents are saved to the store pending commit.
+func newAttachment(f *email.File, store *AttachmentStore) (Attachment, error) {
+	a := Attachment{Name: f.Name, Disposition: f.FileType}
+	if f.ContentInfo != nil {
+		a.ContentType = f.ContentInfo.Type
+	}
+	if store != nil {
+		return a, store.save(f.Reader, &a)
+	}
+	cr := &countingReader{r: f.Reader}
+	sum, err := SHA256(cr)
+	if err != nil {
+		return a, fmt.Errorf("attachment %s reading error, %w", f.Name, err)
+	}
+	a.Size = cr.n
+	a.SHA256 = fmt.Sprintf("%x", sum)
+	return a, nil
+}
+
+// attachmentSizeUnits are the multipliers of the size suffixes
+// accepted by parseSize
+var attachmentSizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+}
+
+// parseSize parses a size such as "500", "200KB" or "2.5MB", using
+// binary multiples
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	unit, ok := attachmentSizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q, expected B, KB, MB or GB", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// AttachmentFilter describes a filter on the attachments of an email.
+// An attachment matches if its file name matches the case-insensitive
+// glob Pattern, such as "*.xlsx", and it is at least MinSize bytes, if
+// these are set. An email passes the filter if it has a matching
+// attachment or, if Exclude is true, if it has none.
+type AttachmentFilter struct {
+	Name    string
+	Pattern string
+	MinSize int64
+	Exclude bool
+}
+
+func (a AttachmentFilter) String() string {
+	return fmt.Sprintf("%s (pattern %q min size %d exclude %t)", a.Name, a.Pattern, a.MinSize, a.Exclude)
+}
+
+// matches reports if an attachment matches the filter
+func (a AttachmentFilter) matches(at Attachment) bool {
+	if a.Pattern != "" {
+		if ok, _ := path.Match(strings.ToLower(a.Pattern), strings.ToLower(at.Name)); !ok {
+			return false
+		}
+	}
+	return at.Size >= a.MinSize
+}
+
+// newFilterByAttachment filters emails by their attachments as
+// described by an AttachmentFilter
+func newFilterByAttachment(a AttachmentFilter) Filter {
+	return newFilter(a.Name, func(e EmailWithSource) bool {
+		for _, at := range e.attachments {
+			if a.matches(at) {
+				return !a.Exclude
+			}
+		}
+		return a.Exclude
+	})
+}
+
+// newAttachmentAnnotator records the number and names of the
+// attachments of an email in the attachmentCountColumn and
+// attachmentNamesColumn
+func newAttachmentAnnotator() annotatorFunc {
+	return func(e *EmailWithSource) {
+		names := []string{}
+		for _, at := range e.attachments {
+			names = append(names, at.Name)
+		}
+		e.setExtra(attachmentCountColumn, strconv.Itoa(len(e.attachments)))
+		e.setExtra(attachmentNamesColumn, strings.Join(names, "; "))
+	}
+}
+
+// WriteAttachments writes an inventory of the attachments of the emails
+// to a csv.Writer, with a row for each attachment, showing dates in the
+// timezone loc. Emails are written in the order provided.
+func (e Emails) WriteAttachments(writer *csv.Writer, loc *time.Location) error {
+	return e.WriteSink(NewAttachmentInventorySink(writer, loc))
+}
+
+// AttachmentInventorySink is a Sink writing an inventory of the
+// attachments of emails to a csv.Writer, with a row for each
+// attachment.
+type AttachmentInventorySink struct {
+	writer  *csv.Writer
+	loc     *time.Location
+	started bool // the header has been written
+}
+
+// NewAttachmentInventorySink makes an AttachmentInventorySink showing
+// dates in the timezone loc
+func NewAttachmentInventorySink(writer *csv.Writer, loc *time.Location) *AttachmentInventorySink {
+	return &AttachmentInventorySink{writer: writer, loc: loc}
+}
+
+// writeHeader writes the csv header, once
+func (s *AttachmentInventorySink) writeHeader() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if err := s.writer.Write(attachmentInventoryHeader); err != nil {
+		return fmt.Errorf("csv header writing error, %w", err)
+	}
+	return nil
+}
+
+// Write writes a row for each attachment of an email
+func (s *AttachmentInventorySink) Write(em EmailWithSource) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	for _, at := range em.attachments {
+		record := []string{
+			em.Date.In(s.loc).Format("2006-01-02"),
+			em.senderAddress(),
+			em.source,
+			string(em.MessageID),
+			at.Name,
+			at.ContentType,
+			at.Disposition,
+			strconv.FormatInt(at.Size, 10),
+			at.SHA256,
+		}
+		if err := s.writer.Write(record); err != nil {
+			return fmt.Errorf("csv writing error, %w", err)
+		}
+	}
+	return nil
+}
+
+// Close writes the header if no emails were written and flushes the
+// csv.Writer
+func (s *AttachmentInventorySink) Close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
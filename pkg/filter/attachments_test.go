@@ -0,0 +1,168 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rorycl/letters"
+	"github.com/rorycl/letters/email"
+)
+
+// attachmentEmail is an email with a text body and two attachments,
+// "hello world\n" in report.xlsx and "abc" in notes.txt
+var attachmentEmail = strings.ReplaceAll(`From: alice@example.com
+To: bob@example.com
+Subject: quarterly figures
+Date: Wed, 01 Jun 2022 10:00:00 +0000
+Message-ID: <attachments@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="XXX"
+
+--XXX
+Content-Type: text/plain; charset=utf-8
+
+Please find the figures attached.
+--XXX
+Content-Type: application/vnd.openxmlformats-officedocument.spreadsheetml.sheet; name="report.xlsx"
+Content-Disposition: attachment; filename="report.xlsx"
+Content-Transfer-Encoding: base64
+
+aGVsbG8gd29ybGQK
+--XXX
+Content-Type: text/plain; name="notes.txt"
+Content-Disposition: attachment; filename="notes.txt"
+
+abc
+--XXX--
+`, "\n", "\r\n")
+
+func TestParseAttachments(t *testing.T) {
+	var attachments []Attachment
+	fileFunc := func(f *email.File) error {
+		a, err := newAttachment(f, nil)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, a)
+		return nil
+	}
+	opts := processOptions{parseAttachments: true}
+	p := letters.NewParser(opts.parserOptions(fileFunc)...)
+	message, err := p.Parse(strings.NewReader(attachmentEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(message.Text), "Please find the figures attached."; got != want {
+		t.Errorf("got text %q want %q", got, want)
+	}
+	want := []Attachment{
+		{
+			Name:        "report.xlsx",
+			ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			Disposition: "attachment",
+			Size:        12,
+			SHA256:      "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+		},
+		{
+			Name:        "notes.txt",
+			ContentType: "text/plain",
+			Disposition: "attachment",
+			Size:        3,
+			SHA256:      "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+	if diff := cmp.Diff(want, attachments, cmpopts.IgnoreUnexported(Attachment{})); diff != "" {
+		t.Errorf("attachments mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{"500", 500, false},
+		{"500B", 500, false},
+		{"2KB", 2048, false},
+		{"1.5 mb", 1572864, false},
+		{"1GB", 1 << 30, false},
+		{"10TB", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got, err := parseSize(tt.size)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, expected error %t", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentFilter(t *testing.T) {
+	e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+	e.attachments = []Attachment{
+		{Name: "Report.XLSX", Size: 2 << 20},
+		{Name: "notes.txt", Size: 3},
+	}
+	none := EmailWithSource{Headers: email.Headers{}, source: "test"}
+
+	tests := []struct {
+		filter AttachmentFilter
+		email  EmailWithSource
+		ok     bool
+	}{
+		{AttachmentFilter{Pattern: "*.xlsx"}, e, true},
+		{AttachmentFilter{Pattern: "*.xlsx"}, none, false},
+		{AttachmentFilter{Pattern: "*.pdf"}, e, false},
+		{AttachmentFilter{Pattern: "*.xlsx", MinSize: 1 << 20}, e, true},
+		{AttachmentFilter{Pattern: "*.txt", MinSize: 1 << 20}, e, false},
+		{AttachmentFilter{MinSize: 5 << 20}, e, false},
+		{AttachmentFilter{}, e, true},
+		{AttachmentFilter{}, none, false},
+		{AttachmentFilter{Pattern: "*.xlsx", Exclude: true}, e, false},
+		{AttachmentFilter{Pattern: "*.xlsx", Exclude: true}, none, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			nf := newFilterByAttachment(tt.filter)
+			if got, want := accepted(nf, tt.email), tt.ok; got != want {
+				t.Errorf("got %t want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteAttachments(t *testing.T) {
+	e := EmailWithSource{Headers: email.Headers{
+		Date:      time.Date(2022, 6, 1, 23, 30, 0, 0, time.UTC),
+		MessageID: "abc@example.com",
+	}, source: "test"}
+	e.attachments = []Attachment{{Name: "notes.txt", ContentType: "text/plain", Disposition: "attachment", Size: 3, SHA256: "ba78"}}
+	newAttachmentAnnotator()(&e)
+	if got, want := e.extra[attachmentCountColumn], "1"; got != want {
+		t.Errorf("got %s want %s attachments", got, want)
+	}
+
+	var buf bytes.Buffer
+	loc, _ := time.LoadLocation("Europe/London")
+	if err := (Emails{e}).WriteAttachments(csv.NewWriter(&buf), loc); err != nil {
+		t.Fatal(err)
+	}
+	want := "date,from,source,id,filename,content type,disposition,size,sha256\n" +
+		"2022-06-02,,test,abc@example.com,notes.txt,text/plain,attachment,3,ba78\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// attachmentIndexFile is the name of the csv index written to an
+// AttachmentStore directory
+const attachmentIndexFile = "index.csv"
+
+// attachmentIndexHeader is the header of the attachment index csv
+var attachmentIndexHeader = []string{"source", "id", "sha256", "filename", "path"}
+
+// AttachmentStore is a content addressed store of attachments in a
+// directory. Each attachment is saved once, however many emails it is
+// found in, at a path made from its sha256 sum such as
+// "a9/a948904f...". Attachments are first written to a temporary file
+// while their sum is calculated and are then either committed to the
+// store or discarded, for example if their email is rejected by the
+// filters. An AttachmentStore is safe for concurrent use.
+type AttachmentStore struct {
+	Dir string
+}
+
+// NewAttachmentStore makes an AttachmentStore in dir, creating the
+// directory if necessary. An error is returned if the directory already
+// has an index, to avoid mixing the results of different reports.
+func NewAttachmentStore(dir string) (*AttachmentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("attachment directory error, %w", err)
+	}
+	s := AttachmentStore{Dir: dir}
+	if _, err := os.Stat(s.IndexPath()); !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("attachment index %s already exists", s.IndexPath())
+	}
+	return &s, nil
+}
+
+// IndexPath returns the path of the index of the store, to be written
+// with Emails.WriteAttachmentIndex
+func (s *AttachmentStore) IndexPath() string {
+	return filepath.Join(s.Dir, attachmentIndexFile)
+}
+
+// save writes the contents of r to a temporary file in the store,
+// recording its size, sha256 sum and temporary path on the attachment.
+func (s *AttachmentStore) save(r io.Reader, a *Attachment) error {
+	f, err := os.CreateTemp(s.Dir, ".attachment-*")
+	if err != nil {
+		return fmt.Errorf("attachment temporary file error, %w", err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("attachment %s saving error, %w", a.Name, err)
+	}
+	a.Size = n
+	a.SHA256 = fmt.Sprintf("%x", hash.Sum(nil))
+	a.tempFile = f.Name()
+	return nil
+}
+
+// commit moves a saved attachment to its path in the store, unless an
+// attachment with the same contents has already been stored, recording
+// the path relative to the store directory on the attachment.
+func (s *AttachmentStore) commit(a *Attachment) error {
+	if a.tempFile == "" {
+		return nil
+	}
+	rel := filepath.Join(a.SHA256[:2], a.SHA256)
+	stored := filepath.Join(s.Dir, rel)
+	if _, err := os.Stat(stored); err == nil {
+		s.discard(a)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(stored), 0o755); err != nil {
+			return fmt.Errorf("attachment directory error, %w", err)
+		}
+		if err := os.Rename(a.tempFile, stored); err != nil {
+			return fmt.Errorf("attachment %s storing error, %w", a.Name, err)
+		}
+		a.tempFile = ""
+	}
+	a.Path = rel
+	return nil
+}
+
+// discard removes the temporary file of a saved attachment
+func (s *AttachmentStore) discard(a *Attachment) {
+	if a.tempFile != "" {
+		os.Remove(a.tempFile)
+		a.tempFile = ""
+	}
+}
+
+// settle commits the saved attachments of an email accepted by the
+// filters to the store, or otherwise discards them. settle does nothing
+// on a nil store.
+func (s *AttachmentStore) settle(attachments []Attachment, accepted bool) error {
+	if s == nil {
+		return nil
+	}
+	for i := range attachments {
+		if !accepted {
+			s.discard(&attachments[i])
+			continue
+		}
+		if err := s.commit(&attachments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAttachmentIndex writes an index of the stored attachments of the
+// emails to a csv.Writer, mapping each email's source and message id to
+// the sha256 sum, original filename and store path of its attachments.
+func (e Emails) WriteAttachmentIndex(writer *csv.Writer) error {
+	return e.WriteSink(NewAttachmentIndexSink(writer))
+}
+
+// AttachmentIndexSink is a Sink writing an index of the stored
+// attachments of emails to a csv.Writer, as described by
+// WriteAttachmentIndex.
+type AttachmentIndexSink struct {
+	writer  *csv.Writer
+	started bool // the header has been written
+}
+
+// NewAttachmentIndexSink makes an AttachmentIndexSink
+func NewAttachmentIndexSink(writer *csv.Writer) *AttachmentIndexSink {
+	return &AttachmentIndexSink{writer: writer}
+}
+
+// writeHeader writes the csv header, once
+func (s *AttachmentIndexSink) writeHeader() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if err := s.writer.Write(attachmentIndexHeader); err != nil {
+		return fmt.Errorf("csv header writing error, %w", err)
+	}
+	return nil
+}
+
+// Write writes a row for each stored attachment of an email
+func (s *AttachmentIndexSink) Write(em EmailWithSource) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	for _, at := range em.attachments {
+		if at.Path == "" {
+			continue
+		}
+		record := []string{em.source, string(em.MessageID), at.SHA256, at.Name, at.Path}
+		if err := s.writer.Write(record); err != nil {
+			return fmt.Errorf("csv writing error, %w", err)
+		}
+	}
+	return nil
+}
+
+// Close writes the header if no emails were written and flushes the
+// csv.Writer
+func (s *AttachmentIndexSink) Close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
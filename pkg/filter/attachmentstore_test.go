@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestAttachmentStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+	store, err := NewAttachmentStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// save the same contents from two emails, and other contents from a
+	// rejected email
+	newEmail := func(id, name, contents string) EmailWithSource {
+		f := &email.File{Name: name, FileType: "attachment", Reader: strings.NewReader(contents)}
+		a, err := newAttachment(f, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := EmailWithSource{Headers: email.Headers{MessageID: id}, source: "test"}
+		e.attachments = []Attachment{a}
+		return e
+	}
+	first := newEmail("first@example.com", "notes.txt", "abc")
+	second := newEmail("second@example.com", "copy of notes.txt", "abc")
+	rejected := newEmail("rejected@example.com", "secret.txt", "secret")
+
+	for _, e := range []EmailWithSource{first, second} {
+		if err := store.settle(e.attachments, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.settle(rejected.attachments, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the single copy of the accepted attachments should remain
+	sum := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	files := []string{}
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(files, ","), filepath.Join("ba", sum); got != want {
+		t.Errorf("got files %s want %s", got, want)
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, "ba", sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "abc"; got != want {
+		t.Errorf("got contents %q want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := (Emails{first, second, rejected}).WriteAttachmentIndex(csv.NewWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("ba", sum)
+	want := "source,id,sha256,filename,path\n" +
+		"test,first@example.com," + sum + ",notes.txt," + path + "\n" +
+		"test,second@example.com," + sum + ",copy of notes.txt," + path + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// a store with an index should not be reused
+	if err := os.WriteFile(filepath.Join(dir, attachmentIndexFile), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAttachmentStore(dir); err == nil {
+		t.Error("expected existing index error")
+	}
+}
@@ -0,0 +1,277 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// authMethods are the authentication methods reported in columns and
+// which may be filtered on
+var authMethods = []string{"spf", "dkim", "dmarc"}
+
+// authDomainColumn is the optional report column showing the domains
+// of passing DKIM signatures; the results of each of authMethods are
+// shown in a column named after the method.
+const authDomainColumn = "dkim domain"
+
+// AuthResult is the result of an authentication method recorded in an
+// Authentication-Results (RFC 8601) or Received-SPF (RFC 7208) header.
+// Domain is the domain authenticated by the method: the signing domain
+// for DKIM, the envelope-from (or HELO) domain for SPF and the From
+// header domain for DMARC.
+type AuthResult struct {
+	ServID string // the authentication service identifier
+	Method string
+	Result string
+	Domain string
+}
+
+// stripComments removes the parenthesised, possibly nested, comments
+// from a header value, respecting quoted strings
+func stripComments(s string) string {
+	var b strings.Builder
+	depth, quoted, escaped := 0, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"' && depth == 0:
+			quoted = !quoted
+		case r == '(' && !quoted:
+			depth++
+			continue
+		case r == ')' && !quoted && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitOutsideQuotes splits s on sep where sep is not within a quoted
+// string
+func splitOutsideQuotes(s string, sep rune) []string {
+	parts := []string{}
+	quoted, start := false, 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + len(string(sep))
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// authProperties parses the space separated "key=value" pairs of a
+// result, such as "dkim=pass header.d=example.com", folding keys to
+// lower case and unquoting values
+func authProperties(s string) map[string]string {
+	props := map[string]string{}
+	for _, f := range strings.Fields(s) {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		props[strings.ToLower(k)] = strings.Trim(v, `"`)
+	}
+	return props
+}
+
+// addressDomain returns the lower case domain of an address, or of a
+// DKIM identity such as "@example.com", or the value itself if it has
+// no "@"
+func addressDomain(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "<>")
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.ToLower(s)
+}
+
+// parseAuthenticationResults parses an Authentication-Results header
+// value such as
+//
+//	mx.example.com; dkim=pass header.d=example.org; spf=fail smtp.mailfrom=a@example.net
+//
+// into its authentication service identifier and results. An empty
+// header has no identifier or results.
+func parseAuthenticationResults(value string) (string, []AuthResult) {
+	parts := splitOutsideQuotes(stripComments(value), ';')
+	results := []AuthResult{}
+	// the authserv-id may be followed by a version number
+	fields := strings.Fields(parts[0])
+	if len(fields) == 0 {
+		return "", results
+	}
+	servID := strings.ToLower(fields[0])
+	for _, part := range parts[1:] {
+		props := authProperties(part)
+		for _, method := range authMethods {
+			// the method may have a version, such as "dkim/1"
+			result, ok := props[method]
+			if !ok {
+				for k, v := range props {
+					if strings.HasPrefix(k, method+"/") {
+						result, ok = v, true
+					}
+				}
+			}
+			if !ok {
+				continue
+			}
+			r := AuthResult{ServID: servID, Method: method, Result: strings.ToLower(result)}
+			switch method {
+			case "dkim":
+				r.Domain = props["header.d"]
+				if r.Domain == "" {
+					r.Domain = addressDomain(props["header.i"])
+				}
+			case "spf":
+				r.Domain = addressDomain(props["smtp.mailfrom"])
+				if r.Domain == "" {
+					r.Domain = addressDomain(props["smtp.helo"])
+				}
+			case "dmarc":
+				r.Domain = props["header.from"]
+			}
+			r.Domain = strings.ToLower(r.Domain)
+			results = append(results, r)
+		}
+	}
+	return servID, results
+}
+
+// parseReceivedSPF parses a Received-SPF header value such as
+//
+//	pass (mx.example.com: domain of a@example.org designates 192.0.2.1 as permitted sender) client-ip=192.0.2.1; envelope-from=a@example.org;
+//
+// An empty header returns a zero AuthResult.
+func parseReceivedSPF(value string) AuthResult {
+	value = stripComments(value)
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return AuthResult{}
+	}
+	r := AuthResult{Method: "spf", Result: strings.ToLower(fields[0])}
+	props := authProperties(strings.ReplaceAll(value, ";", " "))
+	r.ServID = strings.ToLower(props["receiver"])
+	r.Domain = addressDomain(props["envelope-from"])
+	if r.Domain == "" {
+		r.Domain = addressDomain(props["helo"])
+	}
+	return r
+}
+
+// authResults returns the authentication results of an email.
+// Authentication results may be added by any server an email passes
+// through, so only those added by a trusted authentication service, in
+// practice the receiving mail server, should be relied upon. If trusted
+// is empty only the topmost Authentication-Results header, added by the
+// last server to receive the email, is used. A Received-SPF header is
+// only used if the Authentication-Results have no SPF result.
+func (e EmailWithSource) authResults(trusted []string) []AuthResult {
+	isTrusted := func(servID string, i int) bool {
+		if len(trusted) == 0 {
+			return i == 0
+		}
+		for _, t := range trusted {
+			if strings.EqualFold(t, servID) {
+				return true
+			}
+		}
+		return false
+	}
+	results := []AuthResult{}
+	hasSPF := false
+	for i, v := range e.ExtraHeaders["Authentication-Results"] {
+		servID, rs := parseAuthenticationResults(v)
+		if !isTrusted(servID, i) {
+			continue
+		}
+		for _, r := range rs {
+			hasSPF = hasSPF || r.Method == "spf"
+		}
+		results = append(results, rs...)
+	}
+	if hasSPF {
+		return results
+	}
+	for i, v := range e.ExtraHeaders["Received-Spf"] {
+		// Received-SPF headers need not record the receiver, in which
+		// case they are only used if no servers are trusted
+		if r := parseReceivedSPF(v); r.Method != "" && isTrusted(r.ServID, i) {
+			return append(results, r)
+		}
+	}
+	return results
+}
+
+// AuthFilter describes a filter on the authentication results of an
+// email, such as "dkim pass for example.com". An email passes if it
+// has a result for Method equal to Result for Domain, or a subdomain of
+// Domain. An empty Domain matches any domain.
+type AuthFilter struct {
+	Name    string
+	Method  string // one of authMethods
+	Result  string // such as "pass" or "fail"
+	Domain  string
+	Trusted []string // trusted authentication service identifiers
+}
+
+func (a AuthFilter) String() string {
+	return fmt.Sprintf("%s (%s=%s domain %q)", a.Name, a.Method, a.Result, a.Domain)
+}
+
+// matches reports if an authentication result matches the filter
+func (a AuthFilter) matches(r AuthResult) bool {
+	if r.Method != a.Method || r.Result != a.Result {
+		return false
+	}
+	if a.Domain == "" {
+		return true
+	}
+	domain := strings.ToLower(a.Domain)
+	return r.Domain == domain || strings.HasSuffix(r.Domain, "."+domain)
+}
+
+// newFilterByAuth filters emails by their authentication results as
+// described by an AuthFilter
+func newFilterByAuth(a AuthFilter) Filter {
+	return newFilter(a.Name, func(e EmailWithSource) bool {
+		for _, r := range e.authResults(a.Trusted) {
+			if a.matches(r) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// newAuthAnnotator records the results of each of authMethods in a
+// column of the same name, and the domains of passing DKIM signatures
+// in the authDomainColumn. Several results for a method, such as for
+// several DKIM signatures, are separated by "; ".
+func newAuthAnnotator(trusted []string) annotatorFunc {
+	return func(e *EmailWithSource) {
+		results := map[string][]string{}
+		domains := []string{}
+		for _, r := range e.authResults(trusted) {
+			results[r.Method] = append(results[r.Method], r.Result)
+			if r.Method == "dkim" && r.Result == "pass" && r.Domain != "" {
+				domains = append(domains, r.Domain)
+			}
+		}
+		for _, m := range authMethods {
+			e.setExtra(m, strings.Join(results[m], "; "))
+		}
+		e.setExtra(authDomainColumn, strings.Join(domains, "; "))
+	}
+}
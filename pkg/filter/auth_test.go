@@ -0,0 +1,130 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rorycl/letters/email"
+)
+
+func TestParseAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		value  string
+		servID string
+		want   []AuthResult
+	}{
+		{
+			"mx.google.com; dkim=pass header.i=@golang-org.20230601.gappssmtp.com header.s=20230601 header.b=hoPojTYN; " +
+				"spf=pass (google.com: domain of iant@golang.org designates 2607:f8b0:4864:20::435 as permitted sender; really) smtp.mailfrom=iant@golang.org; " +
+				"dmarc=pass (p=NONE sp=NONE dis=NONE) header.from=golang.org",
+			"mx.google.com",
+			[]AuthResult{
+				{"mx.google.com", "dkim", "pass", "golang-org.20230601.gappssmtp.com"},
+				{"mx.google.com", "spf", "pass", "golang.org"},
+				{"mx.google.com", "dmarc", "pass", "golang.org"},
+			},
+		},
+		{
+			"MX.Example.com 1; dkim/1=FAIL header.d=Example.org; dkim=pass header.d=example.net; spf=none smtp.helo=mail.example.org",
+			"mx.example.com",
+			[]AuthResult{
+				{"mx.example.com", "dkim", "fail", "example.org"},
+				{"mx.example.com", "dkim", "pass", "example.net"},
+				{"mx.example.com", "spf", "none", "mail.example.org"},
+			},
+		},
+		{
+			"mx.example.com; none",
+			"mx.example.com",
+			[]AuthResult{},
+		},
+		{"", "", []AuthResult{}},
+		{"   ", "", []AuthResult{}},
+		{"(only a comment)", "", []AuthResult{}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			servID, got := parseAuthenticationResults(tt.value)
+			if servID != tt.servID {
+				t.Errorf("got servID %s want %s", servID, tt.servID)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("results mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseReceivedSPF(t *testing.T) {
+	tests := []struct {
+		value string
+		want  AuthResult
+	}{
+		{
+			"SoftFail (mx.example.com: domain of transitioning a@example.org does not designate 192.0.2.1 as permitted sender) receiver=mx.example.com; client-ip=192.0.2.1; envelope-from=<a@Example.org>;",
+			AuthResult{"mx.example.com", "spf", "softfail", "example.org"},
+		},
+		{"", AuthResult{}},
+		{"   ", AuthResult{}},
+	}
+	for i, tt := range tests {
+		if got := parseReceivedSPF(tt.value); got != tt.want {
+			t.Errorf("%d got %v want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestAuthResultsEmptyHeaders(t *testing.T) {
+	e := EmailWithSource{Headers: email.Headers{ExtraHeaders: map[string][]string{
+		"Authentication-Results": {"", "   "},
+		"Received-Spf":           {" "},
+	}}, source: "test"}
+	if got := e.authResults(nil); len(got) != 0 {
+		t.Errorf("got %v want no results", got)
+	}
+}
+
+func TestAuthResultsTrust(t *testing.T) {
+	e := EmailWithSource{Headers: email.Headers{ExtraHeaders: map[string][]string{
+		"Authentication-Results": {
+			"mx.example.com; dkim=fail header.d=smythersbrown.net",
+			"relay.example.net; dkim=pass header.d=smythersbrown.net; spf=pass smtp.mailfrom=a@smythersbrown.net",
+		},
+		"Received-Spf": {"fail (mx.example.com: not permitted) envelope-from=a@smythersbrown.net;"},
+	}}, source: "test"}
+
+	tests := []struct {
+		trusted []string
+		filter  AuthFilter
+		ok      bool
+		columns []string // spf, dkim, dmarc and dkim domain
+	}{
+		// only the topmost header is trusted by default
+		{nil, AuthFilter{Method: "dkim", Result: "pass", Domain: "smythersbrown.net"}, false, []string{"fail", "fail", "", ""}},
+		{nil, AuthFilter{Method: "dkim", Result: "fail"}, true, []string{"fail", "fail", "", ""}},
+		{[]string{"relay.example.net"}, AuthFilter{Method: "dkim", Result: "pass", Domain: "smythersbrown.net"}, true, []string{"pass", "pass", "", "smythersbrown.net"}},
+		{[]string{"relay.example.net"}, AuthFilter{Method: "dkim", Result: "pass", Domain: "brown.net"}, false, []string{"pass", "pass", "", "smythersbrown.net"}},
+		{[]string{"relay.example.net"}, AuthFilter{Method: "dkim", Result: "pass", Domain: "net"}, true, []string{"pass", "pass", "", "smythersbrown.net"}},
+		{[]string{"other.example.com"}, AuthFilter{Method: "spf", Result: "fail"}, false, []string{"", "", "", ""}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			tt.filter.Name = "auth filter"
+			tt.filter.Trusted = tt.trusted
+			nf := newFilterByAuth(tt.filter)
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t want %t", got, want)
+			}
+			em := e
+			newAuthAnnotator(tt.trusted)(&em)
+			got := []string{}
+			for _, c := range append(authMethods, authDomainColumn) {
+				got = append(got, em.extra[c])
+			}
+			if !cmp.Equal(got, tt.columns) {
+				t.Errorf("got columns %v want %v", got, tt.columns)
+			}
+		})
+	}
+}
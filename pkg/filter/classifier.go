@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+)
+
+// messageClassColumn is the optional report column showing the class
+// of each email
+const messageClassColumn = "class"
+
+// messageClasses are the classes of email determined by classify
+var messageClasses = []string{"human", "auto-reply", "bounce", "list", "bulk"}
+
+// listHeaders are headers added by mailing list software (RFC 2369 and
+// RFC 2919)
+var listHeaders = []string{"List-Id", "List-Post", "List-Unsubscribe", "List-Help", "List-Subscribe", "Mailing-List"}
+
+// extraHeader returns the first value of an extra header, trimmed and
+// folded to lower case, or an empty string if the header is not present
+func (e EmailWithSource) extraHeader(header string) string {
+	if values := e.ExtraHeaders[header]; len(values) > 0 {
+		return strings.ToLower(strings.TrimSpace(values[0]))
+	}
+	return ""
+}
+
+// hasExtraHeader reports if the email has an extra header
+func (e EmailWithSource) hasExtraHeader(header string) bool {
+	_, ok := e.ExtraHeaders[header]
+	return ok
+}
+
+// classify determines the class of an email from its headers, checking
+// in turn for:
+//
+//	bounce     : delivery status notifications (RFC 3464), reported by a
+//	             multipart/report content type, an X-Failed-Recipients
+//	             header or a null Return-Path from a mailer daemon
+//	auto-reply : out of office and other automatic responses, reported
+//	             by an Auto-Submitted header (RFC 3834) other than "no",
+//	             X-Autoreply or X-Autorespond headers, "Precedence:
+//	             auto_reply", read receipts (RFC 8098) or a null
+//	             Return-Path
+//	bulk       : "Precedence: bulk" or "Precedence: junk"
+//	list       : List-* headers or "Precedence: list"
+//
+// falling back to "human".
+func (e EmailWithSource) classify() string {
+	precedence := e.extraHeader("Precedence")
+	nullReturnPath := false
+	for _, rp := range e.ExtraHeaders["Return-Path"] {
+		if strings.Trim(strings.TrimSpace(rp), "<>") == "" {
+			nullReturnPath = true
+		}
+	}
+	var reportType string
+	if ci := e.ContentInfo; ci != nil && strings.EqualFold(ci.Type, "multipart/report") {
+		reportType = strings.ToLower(ci.TypeParams["report-type"])
+	}
+
+	// bounces
+	if reportType == "delivery-status" || e.hasExtraHeader("X-Failed-Recipients") {
+		return "bounce"
+	}
+	if nullReturnPath {
+		local, _, _ := strings.Cut(strings.ToLower(firstAddress(e.From)), "@")
+		if local == "mailer-daemon" || local == "postmaster" {
+			return "bounce"
+		}
+	}
+
+	// automatic replies
+	if as := e.extraHeader("Auto-Submitted"); as != "" && as != "no" {
+		return "auto-reply"
+	}
+	if e.hasExtraHeader("X-Autoreply") || e.hasExtraHeader("X-Autorespond") || precedence == "auto_reply" {
+		return "auto-reply"
+	}
+	if reportType == "disposition-notification" || nullReturnPath {
+		return "auto-reply"
+	}
+
+	// bulk and list traffic
+	if precedence == "bulk" || precedence == "junk" {
+		return "bulk"
+	}
+	if precedence == "list" {
+		return "list"
+	}
+	for _, h := range listHeaders {
+		if e.hasExtraHeader(h) {
+			return "list"
+		}
+	}
+	return "human"
+}
+
+// newClassAnnotator records the class of each email in the
+// messageClassColumn
+func newClassAnnotator() annotatorFunc {
+	return func(e *EmailWithSource) {
+		e.setExtra(messageClassColumn, e.classify())
+	}
+}
+
+// newFilterByClass filters out emails not in one of the classes. The
+// filter relies on the class annotator, which is run before the
+// filters.
+func newFilterByClass(name string, classes []string) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return slices.Contains(classes, e.extra[messageClassColumn])
+	})
+}
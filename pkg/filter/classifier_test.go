@@ -0,0 +1,71 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"testing"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		headers     map[string][]string
+		from        string
+		contentType string
+		typeParams  map[string]string
+		want        string
+	}{
+		{map[string][]string{}, "alice@example.com", "text/plain", nil, "human"},
+		{map[string][]string{"Auto-Submitted": {"no"}}, "alice@example.com", "text/plain", nil, "human"},
+		{map[string][]string{"Return-Path": {"<alice@example.com>"}}, "alice@example.com", "text/plain", nil, "human"},
+		{map[string][]string{}, "mailer-daemon@example.com", "multipart/report", map[string]string{"report-type": "delivery-status"}, "bounce"},
+		{map[string][]string{"X-Failed-Recipients": {"bob@example.com"}}, "alice@example.com", "text/plain", nil, "bounce"},
+		{map[string][]string{"Return-Path": {"<>"}}, "MAILER-DAEMON@example.com", "text/plain", nil, "bounce"},
+		{map[string][]string{"Return-Path": {"<>"}}, "postmaster@example.com", "text/plain", nil, "bounce"},
+		{map[string][]string{"Auto-Submitted": {"auto-replied"}}, "alice@example.com", "text/plain", nil, "auto-reply"},
+		{map[string][]string{"Auto-Submitted": {"Auto-Generated"}, "List-Id": {"<x>"}}, "alice@example.com", "text/plain", nil, "auto-reply"},
+		{map[string][]string{"X-Autoreply": {"yes"}}, "alice@example.com", "text/plain", nil, "auto-reply"},
+		{map[string][]string{"Precedence": {"auto_reply"}}, "alice@example.com", "text/plain", nil, "auto-reply"},
+		{map[string][]string{}, "alice@example.com", "multipart/report", map[string]string{"report-type": "disposition-notification"}, "auto-reply"},
+		{map[string][]string{"Return-Path": {"<>"}}, "alice@example.com", "text/plain", nil, "auto-reply"},
+		{map[string][]string{"Precedence": {"Bulk"}, "List-Unsubscribe": {"<mailto:x>"}}, "news@example.com", "text/html", nil, "bulk"},
+		{map[string][]string{"Precedence": {"junk"}}, "news@example.com", "text/html", nil, "bulk"},
+		{map[string][]string{"Precedence": {"list"}}, "alice@example.com", "text/plain", nil, "list"},
+		{map[string][]string{"List-Id": {"<golang-nuts.googlegroups.com>"}}, "alice@example.com", "text/plain", nil, "list"},
+		{map[string][]string{"List-Unsubscribe": {"<mailto:x>"}}, "news@example.com", "text/html", nil, "list"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			e := EmailWithSource{Headers: email.Headers{
+				From:         []*mail.Address{{Address: tt.from}},
+				ExtraHeaders: tt.headers,
+				ContentInfo:  &email.ContentInfo{Type: tt.contentType, TypeParams: tt.typeParams},
+			}, source: "test"}
+			if got := e.classify(); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassFilter(t *testing.T) {
+	annotate := newClassAnnotator()
+	nf := newFilterByClass("message class", []string{"human", "list"})
+	for i, tt := range []struct {
+		headers map[string][]string
+		ok      bool
+	}{
+		{map[string][]string{}, true},
+		{map[string][]string{"List-Id": {"<x>"}}, true},
+		{map[string][]string{"Auto-Submitted": {"auto-replied"}}, false},
+	} {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			e := EmailWithSource{Headers: email.Headers{ExtraHeaders: tt.headers}, source: "test"}
+			annotate(&e)
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t want %t", got, want)
+			}
+		})
+	}
+}
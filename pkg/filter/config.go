@@ -0,0 +1,705 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata" // embed the timezone database for the timezone config
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the result of the desired yaml load.
+type Config struct {
+	ReportStart         time.Time
+	ReportEnd           time.Time
+	Location            *time.Location // timezone for dates
+	InclusiveDates      bool           // include report and holiday boundaries
+	ReceivedIPFragment  string
+	ValidSenderRegexp   *regexp.Regexp
+	SenderAllowList     *AddressList // optional list of valid senders
+	SenderDenyList      *AddressList // optional list of invalid senders
+	SenderIdentity      string       // preferred sender identity, see senderIdentities
+	Holidays            []Holiday
+	WorkingHours        *WorkingHours // optional working hours schedule
+	RecipientFilters    []RecipientFilter
+	HeaderFilters       []HeaderFilter
+	ExpressionFilters   []ExpressionFilter
+	TagRules            []TagRule      // optional rules tagging emails
+	KeywordSearch       *KeywordSearch // optional keyword search, which requires parsing bodies
+	Attachments         bool           // parse attachments, adding attachment columns
+	AttachmentInventory bool           // write an inventory of attachments
+	AttachmentExportDir string         // optional directory to which attachments are exported
+	AttachmentFilters   []AttachmentFilter
+	ClassifyMessages    bool     // add a message class column
+	MessageClasses      []string // optional message classes to include, from messageClasses
+	Authentication      bool     // add authentication result columns
+	TrustedAuthServers  []string // trusted authentication service identifiers
+	AuthFilters         []AuthFilter
+	DKIMKeys            *DKIMKeyStore // optional keys for verifying dkim signatures
+	DKIMResults         []string      // optional dkim verification results to include
+	ExecFilters         []ExecFilter  // optional filters run by helper processes
+	GroupBy             string        // optional key for grouping reports, see groupByKeys
+	Streaming           bool          // write reports as emails are processed
+	StreamSorted        bool          // sort streamed reports by date, see SortingSink
+	StreamMemoryLimit   int           // emails held in memory when sorting streamed reports
+	StreamTempDir       string        // optional directory for sorting temporary files
+	ThreadReport        bool          // add thread columns to the report
+	IncludeWholeThreads bool          // include whole threads with an accepted email
+}
+
+// String describes a Config for printing.
+func (c Config) String() string {
+	t := `
+ReportStart         %s
+ReportEnd           %s
+Location            %s
+InclusiveDates      %t
+ReceivedIPFragment  %s
+ValidSenderRegexp   %s
+ThreadReport        %t
+IncludeWholeThreads %t
+GroupBy             %s
+`
+	s := fmt.Sprintf(t,
+		formatDateTime(c.ReportStart),
+		formatDateTime(c.ReportEnd),
+		c.Location,
+		c.InclusiveDates,
+		c.ReceivedIPFragment,
+		c.ValidSenderRegexp,
+		c.ThreadReport,
+		c.IncludeWholeThreads,
+		c.GroupBy,
+	)
+	for _, h := range c.Holidays {
+		s += fmt.Sprintf("   %s\n", h)
+	}
+	if c.SenderIdentity != "" {
+		s += fmt.Sprintf("SenderIdentity      %s\n", c.SenderIdentity)
+	}
+	if c.SenderAllowList != nil {
+		s += fmt.Sprintf("SenderAllowList     %d entries\n", c.SenderAllowList.Len())
+	}
+	if c.SenderDenyList != nil {
+		s += fmt.Sprintf("SenderDenyList      %d entries\n", c.SenderDenyList.Len())
+	}
+	for _, r := range c.RecipientFilters {
+		s += fmt.Sprintf("RecipientFilter     %s\n", r)
+	}
+	for _, h := range c.HeaderFilters {
+		s += fmt.Sprintf("HeaderFilter        %s\n", h)
+	}
+	for _, x := range c.ExpressionFilters {
+		s += fmt.Sprintf("ExpressionFilter    %s\n", x)
+	}
+	for _, t := range c.TagRules {
+		s += fmt.Sprintf("TagRule             %s\n", t)
+	}
+	if k := c.KeywordSearch; k != nil {
+		s += fmt.Sprintf("KeywordSearch       %s\n", k)
+	}
+	if c.Attachments {
+		s += fmt.Sprintf("Attachments         inventory %t export %q\n", c.AttachmentInventory, c.AttachmentExportDir)
+	}
+	for _, a := range c.AttachmentFilters {
+		s += fmt.Sprintf("AttachmentFilter    %s\n", a)
+	}
+	if c.ClassifyMessages {
+		s += fmt.Sprintf("MessageClasses      %v\n", c.MessageClasses)
+	}
+	if c.Authentication {
+		s += fmt.Sprintf("Authentication      trusted %v\n", c.TrustedAuthServers)
+	}
+	for _, a := range c.AuthFilters {
+		s += fmt.Sprintf("AuthFilter          %s\n", a)
+	}
+	if c.DKIMKeys != nil {
+		s += fmt.Sprintf("DKIMVerification    %d keys include %v\n", c.DKIMKeys.Len(), c.DKIMResults)
+	}
+	for _, x := range c.ExecFilters {
+		s += fmt.Sprintf("ExecFilter          %s\n", x)
+	}
+	if c.Streaming {
+		s += fmt.Sprintf("Streaming           sorted %t memory limit %d temp directory %q\n", c.StreamSorted, c.StreamMemoryLimit, c.StreamTempDir)
+	}
+	if w := c.WorkingHours; w != nil {
+		s += fmt.Sprintf("WorkingHours        %s tag %t\n", w.Location, w.Tag)
+		for d, periods := range w.Schedule {
+			for _, p := range periods {
+				s += fmt.Sprintf("   %-9s %s-%s\n", time.Weekday(d), p.start, p.end)
+			}
+		}
+	}
+	return s
+}
+
+// tagsEmails reports if the Config tags emails, with tag rules, exec
+// filters or the tag action of keyword searches or working hours
+func (c Config) tagsEmails() bool {
+	return len(c.TagRules) > 0 ||
+		len(c.ExecFilters) > 0 ||
+		(c.KeywordSearch != nil && c.KeywordSearch.Tag) ||
+		(c.WorkingHours != nil && c.WorkingHours.Tag)
+}
+
+// formatDateTime formats a time as a date if it is at midnight,
+// otherwise as an RFC 3339 datetime.
+func formatDateTime(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseDateTime parses a date, an RFC 3339 datetime or a datetime
+// without an offset, reporting if only a date was provided. Dates and
+// datetimes without an offset are parsed in the location loc.
+func parseDateTime(s string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", s, loc); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("could not parse %q as a date or RFC 3339 datetime", s)
+}
+
+// UnmarshalYAML is a custom unmarshaller, which uses an auxillary
+// struct (auxConfig) to deal with time.Time and regexp items. Dates are
+// parsed in the timezone set by the IANA name in the timezone key,
+// defaulting to UTC.
+//
+// Report and holiday boundaries may be dates or RFC 3339 datetimes.
+// By default the boundaries are exclusive. If inclusiveDates is set
+// the boundaries are included and an end provided as a date covers the
+// whole of that day.
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+
+	type auxConfig struct {
+		Timezone             string `yaml:"timezone"`
+		location             *time.Location
+		InclusiveDates       bool   `yaml:"inclusiveDates"`
+		ReportStart          string `yaml:"reportStart"`
+		reportStart          time.Time
+		ReportEnd            string `yaml:"reportEnd"`
+		reportEnd            time.Time
+		ReceivedIPFragment   string `yaml:"receivedIPFragment"`
+		ValidSenderRegexpStr string `yaml:"validSenderRegexpStr"`
+		validSenderRegexp    *regexp.Regexp
+		SenderAllowLists     []string `yaml:"senderAllowLists"`
+		senderAllowList      *AddressList
+		SenderDenyLists      []string `yaml:"senderDenyLists"`
+		senderDenyList       *AddressList
+		SenderIdentity       string              `yaml:"senderIdentity"`
+		HolidayStrings       []map[string]string `yaml:"holidayStrings"`
+		HolidayCalendars     []string            `yaml:"holidayCalendars"`
+		holidayStrings       []Holiday
+		WorkingHours         *struct {
+			Timezone string `yaml:"timezone"`
+			Action   string `yaml:"action"`
+			Schedule []struct {
+				Days  string `yaml:"days"`
+				Hours string `yaml:"hours"`
+			} `yaml:"schedule"`
+			PublicHolidays []string `yaml:"publicHolidays"`
+		} `yaml:"workingHours"`
+		workingHours     *WorkingHours
+		RecipientFilters []struct {
+			Name    string   `yaml:"name"`
+			Headers []string `yaml:"headers"`
+			Match   string   `yaml:"match"`
+			Include []string `yaml:"include"`
+			Exclude []string `yaml:"exclude"`
+		} `yaml:"recipientFilters"`
+		recipientFilters []RecipientFilter
+		HeaderFilters    []struct {
+			Name   string `yaml:"name"`
+			Header string `yaml:"header"`
+			Mode   string `yaml:"mode"`
+			Regexp string `yaml:"regexp"`
+		} `yaml:"headerFilters"`
+		headerFilters     []HeaderFilter
+		ExpressionFilters []struct {
+			Name       string `yaml:"name"`
+			Expression string `yaml:"expression"`
+		} `yaml:"expressionFilters"`
+		expressionFilters []ExpressionFilter
+		TagRules          []struct {
+			Tag        string `yaml:"tag"`
+			Expression string `yaml:"expression"`
+		} `yaml:"tagRules"`
+		tagRules      []TagRule
+		KeywordSearch *struct {
+			Query  string   `yaml:"query"`
+			Fields []string `yaml:"fields"`
+			Action string   `yaml:"action"`
+		} `yaml:"keywordSearch"`
+		keywordSearch *KeywordSearch
+		Attachments   *struct {
+			Inventory       bool   `yaml:"inventory"`
+			ExportDirectory string `yaml:"exportDirectory"`
+			Filters         []struct {
+				Name    string `yaml:"name"`
+				Pattern string `yaml:"pattern"`
+				MinSize string `yaml:"minSize"`
+				Exclude bool   `yaml:"exclude"`
+			} `yaml:"filters"`
+		} `yaml:"attachments"`
+		attachmentFilters []AttachmentFilter
+		MessageClassifier *struct {
+			Include []string `yaml:"include"`
+		} `yaml:"messageClassifier"`
+		Authentication *struct {
+			TrustedServers []string `yaml:"trustedServers"`
+			Filters        []struct {
+				Name   string `yaml:"name"`
+				Method string `yaml:"method"`
+				Result string `yaml:"result"`
+				Domain string `yaml:"domain"`
+			} `yaml:"filters"`
+		} `yaml:"authentication"`
+		DKIMVerification *struct {
+			KeyFiles []string `yaml:"keyFiles"`
+			Include  []string `yaml:"include"`
+		} `yaml:"dkimVerification"`
+		ExecFilters []struct {
+			Name        string   `yaml:"name"`
+			Command     []string `yaml:"command"`
+			Timeout     string   `yaml:"timeout"`
+			Concurrency int      `yaml:"concurrency"`
+		} `yaml:"execFilters"`
+		execFilters []ExecFilter
+		GroupBy     string `yaml:"groupBy"`
+		Streaming   *struct {
+			Sorted        bool   `yaml:"sorted"`
+			MemoryLimit   int    `yaml:"memoryLimit"`
+			TempDirectory string `yaml:"tempDirectory"`
+		} `yaml:"streaming"`
+		ThreadReport        bool `yaml:"threadReport"`
+		IncludeWholeThreads bool `yaml:"includeWholeThreads"`
+	}
+
+	var ac auxConfig
+	var err error
+	err = value.Decode(&ac)
+	if err != nil {
+		return err
+	}
+	ac.location, err = time.LoadLocation(ac.Timezone)
+	if err != nil {
+		return fmt.Errorf("timezone error, %w", err)
+	}
+	tp := func(s string) (time.Time, error) {
+		t, _, err := parseDateTime(s, ac.location)
+		return t, err
+	}
+	// tpEnd extends an inclusive end date to the last instant of the day
+	tpEnd := func(s string) (time.Time, error) {
+		t, dateOnly, err := parseDateTime(s, ac.location)
+		if err != nil || !dateOnly || !ac.InclusiveDates {
+			return t, err
+		}
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	ac.reportStart, err = tp(ac.ReportStart)
+	if err != nil {
+		return err
+	}
+	ac.reportEnd, err = tpEnd(ac.ReportEnd)
+	if err != nil {
+		return err
+	}
+	if ac.ReceivedIPFragment == "" {
+		return errors.New("no ip fragment found in config")
+	}
+	if ac.ValidSenderRegexpStr == "" && len(ac.SenderAllowLists) == 0 {
+		return errors.New("no regex string or sender allow lists found in config")
+	}
+	if ac.ValidSenderRegexpStr != "" {
+		ac.validSenderRegexp, err = regexp.Compile(ac.ValidSenderRegexpStr)
+		if err != nil {
+			return err
+		}
+	}
+	if ac.SenderIdentity != "" {
+		if err := validSenderIdentity(ac.SenderIdentity); err != nil {
+			return err
+		}
+	}
+	if len(ac.SenderAllowLists) > 0 {
+		ac.senderAllowList = NewAddressList()
+		if err := ac.senderAllowList.LoadFiles(ac.SenderAllowLists...); err != nil {
+			return err
+		}
+	}
+	if len(ac.SenderDenyLists) > 0 {
+		ac.senderDenyList = NewAddressList()
+		if err := ac.senderDenyList.LoadFiles(ac.SenderDenyLists...); err != nil {
+			return err
+		}
+	}
+	for _, h := range ac.HolidayStrings {
+		if len(h) != 2 {
+			return fmt.Errorf("Expected 2 date arguments, got %s", h)
+		}
+		start, ok := h["start"]
+		if !ok {
+			return fmt.Errorf("no start key for holiday %s", h)
+		}
+		end, ok := h["end"]
+		if !ok {
+			return fmt.Errorf("no start key for holiday %s", h)
+		}
+		startDate, err := tp(start)
+		if err != nil {
+			return err
+		}
+		endDate, err := tpEnd(end)
+		if err != nil {
+			return err
+		}
+		if endDate.Before(startDate) {
+			return fmt.Errorf("holiday %s after %s", formatDateTime(startDate), formatDateTime(endDate))
+		}
+		ac.holidayStrings = append(ac.holidayStrings, Holiday{startDate, endDate})
+	}
+	for _, file := range ac.HolidayCalendars {
+		holidays, err := holidaysFromICSFile(file, ac.location, ac.reportStart, ac.reportEnd, ac.InclusiveDates)
+		if err != nil {
+			return fmt.Errorf("holiday calendar error, %w", err)
+		}
+		ac.holidayStrings = append(ac.holidayStrings, holidays...)
+	}
+	if wh := ac.WorkingHours; wh != nil {
+		w := WorkingHours{
+			Location:       ac.location,
+			PublicHolidays: map[string]struct{}{},
+		}
+		if wh.Timezone != "" {
+			w.Location, err = time.LoadLocation(wh.Timezone)
+			if err != nil {
+				return fmt.Errorf("working hours timezone error, %w", err)
+			}
+		}
+		switch wh.Action {
+		case "", "exclude":
+		case "tag":
+			w.Tag = true
+		default:
+			return fmt.Errorf("working hours action %q should be exclude or tag", wh.Action)
+		}
+		if len(wh.Schedule) == 0 {
+			return errors.New("no schedule found for working hours")
+		}
+		for _, s := range wh.Schedule {
+			if err := w.addSchedule(s.Days, s.Hours); err != nil {
+				return fmt.Errorf("working hours error, %w", err)
+			}
+		}
+		for _, h := range wh.PublicHolidays {
+			if strings.HasSuffix(strings.ToLower(h), ".ics") {
+				holidays, err := holidaysFromICSFile(h, w.Location, ac.reportStart, ac.reportEnd, false)
+				if err != nil {
+					return fmt.Errorf("public holiday calendar error, %w", err)
+				}
+				w.addPublicHolidays(holidays)
+				continue
+			}
+			d, err := time.Parse("2006-01-02", h)
+			if err != nil {
+				return fmt.Errorf("public holiday error, %w", err)
+			}
+			w.PublicHolidays[d.Format("2006-01-02")] = struct{}{}
+		}
+		ac.workingHours = &w
+	}
+	for i, rf := range ac.RecipientFilters {
+		r := RecipientFilter{Name: rf.Name, Headers: recipientHeaders}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("recipient filter %d", i+1)
+		}
+		if len(rf.Headers) > 0 {
+			r.Headers = []string{}
+			for _, h := range rf.Headers {
+				h = strings.ToLower(h)
+				if !slices.Contains(recipientHeaders, h) {
+					return fmt.Errorf("recipient filter %q header %q not one of %v", r.Name, h, recipientHeaders)
+				}
+				r.Headers = append(r.Headers, h)
+			}
+		}
+		switch rf.Match {
+		case "", "any":
+		case "all":
+			r.MatchAll = true
+		default:
+			return fmt.Errorf("recipient filter %q match %q should be any or all", r.Name, rf.Match)
+		}
+		if len(rf.Include) == 0 && len(rf.Exclude) == 0 {
+			return fmt.Errorf("recipient filter %q has no include or exclude patterns", r.Name)
+		}
+		for _, p := range rf.Include {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("recipient filter %q include error, %w", r.Name, err)
+			}
+			r.Include = append(r.Include, re)
+		}
+		for _, p := range rf.Exclude {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("recipient filter %q exclude error, %w", r.Name, err)
+			}
+			r.Exclude = append(r.Exclude, re)
+		}
+		ac.recipientFilters = append(ac.recipientFilters, r)
+	}
+	for i, hf := range ac.HeaderFilters {
+		h := HeaderFilter{
+			Name:   hf.Name,
+			Header: textproto.CanonicalMIMEHeaderKey(hf.Header),
+			Mode:   hf.Mode,
+		}
+		if h.Name == "" {
+			h.Name = fmt.Sprintf("header filter %d", i+1)
+		}
+		if hf.Header == "" {
+			return fmt.Errorf("header filter %q has no header", h.Name)
+		}
+		if slices.Contains(parsedHeaders, h.Header) {
+			return fmt.Errorf("header filter %q header %s is parsed into its own field, use the dedicated filters or an expression filter instead", h.Name, h.Header)
+		}
+		if !slices.Contains(headerMatchModes, h.Mode) {
+			return fmt.Errorf("header filter %q mode %q not one of %v", h.Name, h.Mode, headerMatchModes)
+		}
+		if h.Mode != "exists" {
+			if hf.Regexp == "" {
+				return fmt.Errorf("header filter %q has no regexp", h.Name)
+			}
+			h.Regexp, err = regexp.Compile(hf.Regexp)
+			if err != nil {
+				return fmt.Errorf("header filter %q regexp error, %w", h.Name, err)
+			}
+		}
+		ac.headerFilters = append(ac.headerFilters, h)
+	}
+	for i, xf := range ac.ExpressionFilters {
+		name := xf.Name
+		if name == "" {
+			name = fmt.Sprintf("expression filter %d", i+1)
+		}
+		if xf.Expression == "" {
+			return fmt.Errorf("expression filter %q has no expression", name)
+		}
+		x, err := NewExpressionFilter(name, xf.Expression, ac.location)
+		if err != nil {
+			return err
+		}
+		ac.expressionFilters = append(ac.expressionFilters, *x)
+	}
+	for i, tr := range ac.TagRules {
+		if tr.Tag == "" {
+			return fmt.Errorf("tag rule %d has no tag", i+1)
+		}
+		if tr.Expression == "" {
+			return fmt.Errorf("tag rule %q has no expression", tr.Tag)
+		}
+		t, err := NewTagRule(tr.Tag, tr.Expression, ac.location)
+		if err != nil {
+			return err
+		}
+		ac.tagRules = append(ac.tagRules, t)
+	}
+	if ks := ac.KeywordSearch; ks != nil {
+		for _, f := range ks.Fields {
+			if !slices.Contains(keywordFields, f) {
+				return fmt.Errorf("keyword search field %q not one of %v", f, keywordFields)
+			}
+		}
+		var tag bool
+		switch ks.Action {
+		case "", "exclude":
+		case "tag":
+			tag = true
+		default:
+			return fmt.Errorf("keyword search action %q should be exclude or tag", ks.Action)
+		}
+		ac.keywordSearch, err = NewKeywordSearch(ks.Query, ks.Fields, tag)
+		if err != nil {
+			return err
+		}
+	}
+	var exportDir string
+	if at := ac.Attachments; at != nil {
+		exportDir = at.ExportDirectory
+		for i, af := range at.Filters {
+			a := AttachmentFilter{Name: af.Name, Pattern: af.Pattern, Exclude: af.Exclude}
+			if a.Name == "" {
+				a.Name = fmt.Sprintf("attachment filter %d", i+1)
+			}
+			if _, err := path.Match(a.Pattern, ""); err != nil {
+				return fmt.Errorf("attachment filter %q pattern error, %w", a.Name, err)
+			}
+			if af.MinSize != "" {
+				a.MinSize, err = parseSize(af.MinSize)
+				if err != nil {
+					return fmt.Errorf("attachment filter %q error, %w", a.Name, err)
+				}
+			}
+			ac.attachmentFilters = append(ac.attachmentFilters, a)
+		}
+	}
+	var classes []string
+	if mc := ac.MessageClassifier; mc != nil {
+		for _, class := range mc.Include {
+			if !slices.Contains(messageClasses, class) {
+				return fmt.Errorf("message class %q not one of %v", class, messageClasses)
+			}
+		}
+		classes = mc.Include
+	}
+	var trustedAuthServers []string
+	var authFilters []AuthFilter
+	if au := ac.Authentication; au != nil {
+		trustedAuthServers = au.TrustedServers
+		for i, af := range au.Filters {
+			a := AuthFilter{
+				Name:    af.Name,
+				Method:  strings.ToLower(af.Method),
+				Result:  strings.ToLower(af.Result),
+				Domain:  af.Domain,
+				Trusted: au.TrustedServers,
+			}
+			if a.Name == "" {
+				a.Name = fmt.Sprintf("authentication filter %d", i+1)
+			}
+			if !slices.Contains(authMethods, a.Method) {
+				return fmt.Errorf("authentication filter %q method %q not one of %v", a.Name, af.Method, authMethods)
+			}
+			if a.Result == "" {
+				a.Result = "pass"
+			}
+			authFilters = append(authFilters, a)
+		}
+	}
+	var dkimKeys *DKIMKeyStore
+	var dkimResults []string
+	if dv := ac.DKIMVerification; dv != nil {
+		dkimKeys = NewDKIMKeyStore()
+		if err := dkimKeys.LoadFiles(dv.KeyFiles...); err != nil {
+			return err
+		}
+		for _, r := range dv.Include {
+			if !slices.Contains(dkimVerificationResults, r) {
+				return fmt.Errorf("dkim verification result %q not one of %v", r, dkimVerificationResults)
+			}
+		}
+		dkimResults = dv.Include
+	}
+	for i, xf := range ac.ExecFilters {
+		x := ExecFilter{
+			Name:        xf.Name,
+			Command:     xf.Command,
+			Timeout:     defaultExecTimeout,
+			Concurrency: xf.Concurrency,
+		}
+		if x.Name == "" {
+			x.Name = fmt.Sprintf("exec filter %d", i+1)
+		}
+		if len(x.Command) == 0 || x.Command[0] == "" {
+			return fmt.Errorf("exec filter %q has no command", x.Name)
+		}
+		if xf.Timeout != "" {
+			x.Timeout, err = time.ParseDuration(xf.Timeout)
+			if err != nil {
+				return fmt.Errorf("exec filter %q timeout error, %w", x.Name, err)
+			}
+			if x.Timeout <= 0 {
+				return fmt.Errorf("exec filter %q timeout should be positive", x.Name)
+			}
+		}
+		switch {
+		case x.Concurrency == 0:
+			x.Concurrency = defaultExecConcurrency
+		case x.Concurrency < 0:
+			return fmt.Errorf("exec filter %q concurrency should be positive", x.Name)
+		}
+		ac.execFilters = append(ac.execFilters, x)
+	}
+	if ac.GroupBy != "" && !slices.Contains(groupByKeys, ac.GroupBy) {
+		return fmt.Errorf("group by %q not one of %v", ac.GroupBy, groupByKeys)
+	}
+	var streamSorted bool
+	var streamMemoryLimit int
+	var streamTempDir string
+	if s := ac.Streaming; s != nil {
+		if ac.ThreadReport || ac.IncludeWholeThreads {
+			return errors.New("streaming cannot be used with thread reports")
+		}
+		streamSorted, streamMemoryLimit, streamTempDir = s.Sorted, s.MemoryLimit, s.TempDirectory
+		switch {
+		case streamMemoryLimit == 0:
+			streamMemoryLimit = DefaultSortLimit
+		case streamMemoryLimit < 0:
+			return errors.New("streaming memory limit should be positive")
+		}
+	}
+	*c = Config{
+		ReportStart:         ac.reportStart,
+		ReportEnd:           ac.reportEnd,
+		Location:            ac.location,
+		InclusiveDates:      ac.InclusiveDates,
+		ReceivedIPFragment:  ac.ReceivedIPFragment,
+		ValidSenderRegexp:   ac.validSenderRegexp,
+		SenderAllowList:     ac.senderAllowList,
+		SenderDenyList:      ac.senderDenyList,
+		SenderIdentity:      ac.SenderIdentity,
+		Holidays:            ac.holidayStrings,
+		WorkingHours:        ac.workingHours,
+		RecipientFilters:    ac.recipientFilters,
+		HeaderFilters:       ac.headerFilters,
+		ExpressionFilters:   ac.expressionFilters,
+		TagRules:            ac.tagRules,
+		KeywordSearch:       ac.keywordSearch,
+		Attachments:         ac.Attachments != nil,
+		AttachmentInventory: ac.Attachments != nil && ac.Attachments.Inventory,
+		AttachmentExportDir: exportDir,
+		ClassifyMessages:    ac.MessageClassifier != nil,
+		MessageClasses:      classes,
+		Authentication:      ac.Authentication != nil,
+		TrustedAuthServers:  trustedAuthServers,
+		AuthFilters:         authFilters,
+		DKIMKeys:            dkimKeys,
+		DKIMResults:         dkimResults,
+		ExecFilters:         ac.execFilters,
+		AttachmentFilters:   ac.attachmentFilters,
+		GroupBy:             ac.GroupBy,
+		Streaming:           ac.Streaming != nil,
+		StreamSorted:        streamSorted,
+		StreamMemoryLimit:   streamMemoryLimit,
+		StreamTempDir:       streamTempDir,
+		// including whole threads requires threading
+		ThreadReport:        ac.ThreadReport || ac.IncludeWholeThreads,
+		IncludeWholeThreads: ac.IncludeWholeThreads,
+	}
+	return nil
+}
+
+// LoadYaml loads a Config from a slice of bytes
+func LoadYaml(yamlByte []byte) (Config, error) {
+	var config Config
+	err := yaml.Unmarshal(yamlByte, &config)
+	if err != nil {
+		return config, err
+	}
+	return config, nil
+}
@@ -0,0 +1,746 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/mail"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestConfigFail(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2023-03-12"
+# receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-02-20"
+    end: "2022-02-22"
+  -
+    start: "2022-02-25"
+    end: "2022-02-27"
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected receivedIPFragment error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigFail2(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2023-03-12"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-02-23"
+    end: "2022-02-22"
+  -
+    start: "2022-02-25"
+    end: "2022-02-27"
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected holiday start > end error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigOK(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2023-03-12"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-02-21"
+    end: "2022-02-22"
+  -
+    start: "2022-02-25"
+    end: "2022-02-27"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := config.ReceivedIPFragment, "10.1.99."; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestConfigTimezone(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01"
+reportEnd:   "2022-06-30"
+timezone: "Europe/London"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-06-10"
+    end: "2022-06-12"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := config.ReportStart.UTC(), time.Date(2022, 5, 31, 23, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := config.Holidays[0].Start.UTC(), time.Date(2022, 6, 9, 23, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	// an email sent at 00:30 BST on the first day of the report
+	nf := newFilterByReportDate("report date filter", config.ReportStart, config.ReportEnd, config.InclusiveDates)
+	e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+	e.Date = time.Date(2022, 5, 31, 23, 30, 0, 0, time.UTC)
+	e.From = []*mail.Address{&mail.Address{Address: "this@example.com"}}
+	if !accepted(nf, e) {
+		t.Errorf("expected %s to be within the report period", e.Date)
+	}
+	if got, want := e.forCSV(0, config.Location)[0], "2022-06-01"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestConfigTimezoneFail(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01"
+reportEnd:   "2022-06-30"
+timezone: "Europe/Atlantis"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected timezone error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigDateTimes(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01T09:00:00+01:00"
+reportEnd:   "2022-06-30"
+timezone: "Europe/London"
+inclusiveDates: true
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-06-10T13:00:00"
+    end: "2022-06-10T17:30:00"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	london, _ := time.LoadLocation("Europe/London")
+	for i, tt := range []struct {
+		got, want time.Time
+	}{
+		{config.ReportStart, time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC)},
+		// an inclusive end date covers the whole day
+		{config.ReportEnd, time.Date(2022, 7, 1, 0, 0, 0, 0, london).Add(-time.Nanosecond)},
+		{config.Holidays[0].Start, time.Date(2022, 6, 10, 13, 0, 0, 0, london)},
+		{config.Holidays[0].End, time.Date(2022, 6, 10, 17, 30, 0, 0, london)},
+	} {
+		if !tt.got.Equal(tt.want) {
+			t.Errorf("test %d got %s want %s", i, tt.got, tt.want)
+		}
+	}
+}
+
+func TestConfigDateTimesFail(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01 09:00"
+reportEnd:   "2022-06-30"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected datetime parsing error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigWorkingHours(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01"
+reportEnd:   "2022-06-30"
+timezone: "Europe/London"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+workingHours:
+  action: tag
+  schedule:
+    - days: "Mon-Fri"
+      hours: "09:00-17:30"
+  publicHolidays:
+    - "2022-06-02"
+    - "2022-06-03"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	w := config.WorkingHours
+	if w == nil {
+		t.Fatal("expected working hours")
+	}
+	if got, want := w.Location.String(), "Europe/London"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if !w.Tag {
+		t.Error("expected working hours tag action")
+	}
+	if got, want := len(w.Schedule[time.Friday]), 1; got != want {
+		t.Errorf("got %d want %d Friday periods", got, want)
+	}
+	if got, want := len(w.PublicHolidays), 2; got != want {
+		t.Errorf("got %d want %d public holidays", got, want)
+	}
+}
+
+func TestConfigWorkingHoursCalendar(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+timezone: "Europe/London"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+workingHours:
+  schedule:
+    - days: "Mon-Fri"
+      hours: "09:00-17:30"
+  publicHolidays:
+    - "2022-12-26"
+    - "testdata/leave.ics"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	got := slices.Sorted(maps.Keys(config.WorkingHours.PublicHolidays))
+	want := []string{
+		"2022-03-21", "2022-03-22", "2022-03-23", "2022-03-24", "2022-03-25",
+		"2022-04-01", "2022-04-05", "2022-04-29", "2022-05-13", "2022-12-26",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	yaml = bytes.Replace(yaml, []byte("testdata/leave.ics"), []byte("testdata/missing.ics"), 1)
+	if _, err := LoadYaml(yaml); err == nil {
+		t.Error("expected public holiday calendar error")
+	}
+}
+
+func TestConfigWorkingHoursFail(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-06-01"
+reportEnd:   "2022-06-30"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+workingHours:
+  action: ignore
+  schedule:
+    - days: "Mon-Fri"
+      hours: "09:00-17:30"
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected working hours action error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigHolidayCalendars(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+timezone: "Europe/London"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+holidayStrings: 
+  -
+    start: "2022-02-21"
+    end: "2022-02-22"
+holidayCalendars:
+  - "testdata/leave.ics"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := len(config.Holidays), 6; got != want {
+		t.Errorf("got %d want %d holidays", got, want)
+	}
+}
+
+func TestConfigRecipientFilters(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+recipientFilters:
+  - name: "to client"
+    headers: ["To", "cc"]
+    match: any
+    include: ["(?i)@client\\.com$"]
+  - exclude: ["(?i)^counsel@"]
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := len(config.RecipientFilters), 2; got != want {
+		t.Fatalf("got %d want %d recipient filters", got, want)
+	}
+	if got, want := fmt.Sprint(config.RecipientFilters[0].Headers), "[to cc]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := config.RecipientFilters[1].Name, "recipient filter 2"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := len(config.RecipientFilters[1].Headers), len(recipientHeaders); got != want {
+		t.Errorf("got %d want %d headers", got, want)
+	}
+}
+
+func TestConfigRecipientFiltersFail(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)(this|that|another.com)"
+recipientFilters:
+  - headers: ["Reply-To"]
+    include: ["(?i)@client\\.com$"]
+`)
+
+	_, err := LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected recipient header error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigSenderLists(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+senderAllowLists: ["testdata/custodians.txt"]
+senderDenyLists: ["testdata/excluded.csv"]
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if config.ValidSenderRegexp != nil {
+		t.Errorf("expected no sender regexp, got %s", config.ValidSenderRegexp)
+	}
+	if got, want := config.SenderAllowList.Len(), 5; got != want {
+		t.Errorf("got %d want %d allow list entries", got, want)
+	}
+	if got, want := config.SenderDenyList.Len(), 2; got != want {
+		t.Errorf("got %d want %d deny list entries", got, want)
+	}
+}
+
+func TestConfigHeaderFilters(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+headerFilters:
+  - header: "list-id"
+    mode: "exists"
+  - name: "not bulk"
+    header: "precedence"
+    mode: "not-matches"
+    regexp: "(?i)bulk|list"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := len(config.HeaderFilters), 2; got != want {
+		t.Fatalf("got %d want %d header filters", got, want)
+	}
+	if got, want := config.HeaderFilters[0].Header, "List-Id"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := config.HeaderFilters[0].Name, "header filter 1"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if config.HeaderFilters[1].Regexp == nil {
+		t.Errorf("expected a regexp for %s", config.HeaderFilters[1].Name)
+	}
+}
+
+func TestConfigHeaderFiltersFail(t *testing.T) {
+	for i, filter := range []string{
+		`{header: "List-Id", mode: "contains", regexp: "news"}`,
+		`{header: "List-Id", mode: "matches"}`,
+		`{mode: "exists"}`,
+		`{header: "List-Id", mode: "matches", regexp: "(news"}`,
+		`{header: "subject", mode: "matches", regexp: "news"}`,
+		`{header: "message-id", mode: "exists"}`,
+	} {
+		yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+headerFilters: [` + filter + `]
+`)
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			_, err := LoadYaml(yaml)
+			if err == nil {
+				t.Fatalf("expected header filter error for %s", filter)
+			}
+			fmt.Println(err)
+		})
+	}
+}
+
+func TestConfigAttachments(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+attachments:
+  inventory: true
+  exportDirectory: "attachments"
+  filters:
+    - pattern: "*.xlsx"
+      minSize: "2MB"
+    - name: "no executables"
+      pattern: "*.exe"
+      exclude: true
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if !config.Attachments || !config.AttachmentInventory {
+		t.Errorf("expected attachments and inventory to be set")
+	}
+	if got, want := config.AttachmentExportDir, "attachments"; got != want {
+		t.Errorf("got %s want %s export directory", got, want)
+	}
+	if got, want := len(config.AttachmentFilters), 2; got != want {
+		t.Fatalf("got %d want %d attachment filters", got, want)
+	}
+	if got, want := config.AttachmentFilters[0].MinSize, int64(2<<20); got != want {
+		t.Errorf("got %d want %d min size", got, want)
+	}
+	if got, want := config.AttachmentFilters[0].Name, "attachment filter 1"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = append(yaml, []byte(`    - minSize: "2PB"
+`)...)
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected attachment size error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigMessageClassifier(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+messageClassifier:
+  include: ["human", "list"]
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if !config.ClassifyMessages {
+		t.Error("expected messages to be classified")
+	}
+	if got, want := fmt.Sprint(config.MessageClasses), "[human list]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = bytes.ReplaceAll(yaml, []byte(`"list"`), []byte(`"spam"`))
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected message class error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigAuthentication(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+authentication:
+  trustedServers: ["mx.example.com"]
+  filters:
+    - method: "DKIM"
+      domain: "smythersbrown.net"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if !config.Authentication {
+		t.Error("expected authentication columns")
+	}
+	if got, want := len(config.AuthFilters), 1; got != want {
+		t.Fatalf("got %d want %d authentication filters", got, want)
+	}
+	a := config.AuthFilters[0]
+	if got, want := fmt.Sprint(a.Method, a.Result, a.Trusted), "dkimpass[mx.example.com]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = bytes.ReplaceAll(yaml, []byte(`"DKIM"`), []byte(`"arc"`))
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected authentication method error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigDKIMVerification(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+dkimVerification:
+  keyFiles: ["testdata/dkim.zone", "testdata/dkim-keys.yaml"]
+  include: ["pass", "no-key"]
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := config.DKIMKeys.Len(), 3; got != want {
+		t.Errorf("got %d want %d keys", got, want)
+	}
+	if got, want := fmt.Sprint(config.DKIMResults), "[pass no-key]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = bytes.ReplaceAll(yaml, []byte(`"no-key"`), []byte(`"unknown"`))
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected dkim verification result error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigExecFilters(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+execFilters:
+  - name: classifier
+    command: ["python3", "classify.py"]
+    timeout: 2s
+    concurrency: 4
+  - command: ["./custodians"]
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := len(config.ExecFilters), 2; got != want {
+		t.Fatalf("got %d want %d exec filters", got, want)
+	}
+	if got, want := config.ExecFilters[0].String(), "classifier (python3 classify.py) timeout 2s concurrency 4"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := config.ExecFilters[1].String(), "exec filter 2 (./custodians) timeout 10s concurrency 1"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	for _, r := range [][2]string{
+		{"timeout: 2s", "timeout: 2 seconds"},
+		{"concurrency: 4", "concurrency: -1"},
+		{`["./custodians"]`, "[]"},
+	} {
+		_, err = LoadYaml(bytes.ReplaceAll(yaml, []byte(r[0]), []byte(r[1])))
+		if err == nil {
+			t.Fatalf("expected exec filter error for %s", r[1])
+		}
+		fmt.Println(err)
+	}
+}
+
+func TestConfigExpressionFilters(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+timezone: "Europe/London"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+expressionFilters:
+  - name: after hours counsel
+    expression: 'sender endsWith "@counsel.com" and date.Hour() >= 18'
+  - expression: 'len(hops) < 10'
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := len(config.ExpressionFilters), 2; got != want {
+		t.Fatalf("got %d want %d expression filters", got, want)
+	}
+	if got, want := config.ExpressionFilters[1].String(), "expression filter 2 (len(hops) < 10)"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := config.ExpressionFilters[0].Location.String(), "Europe/London"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = bytes.ReplaceAll(yaml, []byte("len(hops) < 10"), []byte("len(hops) < ten"))
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected expression error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigGroupBy(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+groupBy: "tag"
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := config.GroupBy, "tag"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	yaml = bytes.ReplaceAll(yaml, []byte(`"tag"`), []byte(`"custodian"`))
+	_, err = LoadYaml(yaml)
+	if err == nil {
+		t.Fatalf("expected group by error")
+	}
+	fmt.Println(err)
+}
+
+func TestConfigStreaming(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+streaming:
+  sorted: true
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if !config.Streaming || !config.StreamSorted {
+		t.Errorf("streaming %t sorted %t want true", config.Streaming, config.StreamSorted)
+	}
+	if got, want := config.StreamMemoryLimit, DefaultSortLimit; got != want {
+		t.Errorf("got memory limit %d want %d", got, want)
+	}
+	fmt.Println(config)
+
+	for _, extra := range []string{
+		"  memoryLimit: -1\n",
+		"threadReport: true\n",
+	} {
+		_, err = LoadYaml(append(append([]byte{}, yaml...), extra...))
+		if err == nil {
+			t.Errorf("expected error for %q", extra)
+		}
+		fmt.Println(err)
+	}
+}
+
+func TestConfigTagRules(t *testing.T) {
+	yaml := []byte(`
+reportStart: "2022-01-01"
+reportEnd:   "2022-12-31"
+receivedIPFragment: "10.1.99."
+validSenderRegexpStr: "(?i)example"
+tagRules:
+  - tag: "custodian:alice"
+    expression: '"alice@example.com" in from'
+  - tag: "after-hours"
+    expression: 'date.Hour() >= 18'
+`)
+
+	config, err := LoadYaml(yaml)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+	if got, want := fmt.Sprint(config.TagRules), "[custodian:alice (\"alice@example.com\" in from) after-hours (date.Hour() >= 18)]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if !config.tagsEmails() {
+		t.Error("expected config to tag emails")
+	}
+
+	for _, r := range [][2]string{
+		{"date.Hour() >= 18", "date.Hour() >= 'six'"},
+		{`tag: "after-hours"`, `tag: ""`},
+	} {
+		_, err = LoadYaml(bytes.ReplaceAll(yaml, []byte(r[0]), []byte(r[1])))
+		if err == nil {
+			t.Fatalf("expected tag rule error for %s", r[1])
+		}
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,311 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"gopkg.in/yaml.v3"
+)
+
+// dkimVerificationColumn and dkimVerifiedDomainsColumn are the optional
+// report columns showing the result of verifying the DKIM signatures of
+// an email and the domains of the verified signatures
+const (
+	dkimVerificationColumn    = "dkim verification"
+	dkimVerifiedDomainsColumn = "dkim verified domains"
+)
+
+// dkimVerificationResults are the results of verifying the DKIM
+// signatures of an email: "pass" if any signature verifies, otherwise
+// "fail" if any signature fails, otherwise "expired" if any signature
+// has an expiry time ("x=") in the past, otherwise "no-key" if the key
+// of any signature is not in the key store, otherwise "none" if the
+// email is not signed. Expired signatures are not verified, as
+// go-msgauth checks the expiry time against the current time before
+// verifying a signature, without an option to skip the check. As most
+// signatures of archived emails have expired, filtering on "pass" alone
+// rejects most archived emails.
+var dkimVerificationResults = []string{"pass", "fail", "expired", "no-key", "none"}
+
+// DKIMKeyStore is a local store of DKIM public key records, used in
+// place of DNS lookups to verify archived emails offline. Records are
+// keyed by their DNS name, such as "selector._domainkey.example.com".
+// A DKIMKeyStore is safe for concurrent use once loaded.
+type DKIMKeyStore struct {
+	records map[string]string
+}
+
+// NewDKIMKeyStore makes an empty DKIMKeyStore
+func NewDKIMKeyStore() *DKIMKeyStore {
+	return &DKIMKeyStore{records: map[string]string{}}
+}
+
+// dkimKeyName returns the DNS name of the key for a selector and domain
+func dkimKeyName(selector, domain string) string {
+	return strings.ToLower(selector + "._domainkey." + strings.TrimSuffix(domain, "."))
+}
+
+// Add adds the TXT record of the key for a selector and domain, such as
+// "v=DKIM1; k=rsa; p=MIIBIjANBgkq..."
+func (k *DKIMKeyStore) Add(selector, domain, record string) {
+	k.records[dkimKeyName(selector, domain)] = record
+}
+
+// Len returns the number of keys in the store
+func (k *DKIMKeyStore) Len() int {
+	return len(k.records)
+}
+
+// LoadYAML loads keys from a yaml list of selectors, domains and
+// records, for example:
+//
+//	# keys for example.com
+//	- selector: "20230601"
+//	  domain: "example.com"
+//	  record: "v=DKIM1; k=rsa; p=MIIBIjANBgkq..."
+func (k *DKIMKeyStore) LoadYAML(r io.Reader) error {
+	var keys []struct {
+		Selector string `yaml:"selector"`
+		Domain   string `yaml:"domain"`
+		Record   string `yaml:"record"`
+	}
+	if err := yaml.NewDecoder(r).Decode(&keys); err != nil && err != io.EOF {
+		return err
+	}
+	for i, key := range keys {
+		if key.Selector == "" || key.Domain == "" || key.Record == "" {
+			return fmt.Errorf("key %d requires a selector, domain and record", i+1)
+		}
+		k.Add(key.Selector, key.Domain, key.Record)
+	}
+	return nil
+}
+
+// zoneQuotedStrings returns the concatenated quoted strings of a zone
+// file TXT record, such as `"v=DKIM1; k=rsa; " "p=MIIB..."`
+func zoneQuotedStrings(s string) string {
+	var b strings.Builder
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// zoneLines reads the logical lines of a zone file, removing comments
+// and joining records split over several lines with parentheses
+func zoneLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	var record strings.Builder
+	depth := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		quoted := false
+	chars:
+		for _, c := range scanner.Text() {
+			switch {
+			case c == '"':
+				quoted = !quoted
+			case quoted:
+			case c == ';':
+				break chars // comment
+			case c == '(':
+				depth++
+				c = ' '
+			case c == ')':
+				depth--
+				c = ' '
+			}
+			record.WriteRune(c)
+		}
+		if depth > 0 {
+			record.WriteRune(' ')
+			continue
+		}
+		if line := record.String(); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+		record.Reset()
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in zone file")
+	}
+	return lines, scanner.Err()
+}
+
+// LoadZone loads keys from the TXT records of a DNS zone file, for
+// example:
+//
+//	$ORIGIN example.com.
+//	20230601._domainkey IN TXT ( "v=DKIM1; k=rsa; "
+//	    "p=MIIBIjANBgkq..." )
+//
+// Only TXT records with names containing "._domainkey." are loaded.
+// Names not ending in "." are relative to the current $ORIGIN.
+func (k *DKIMKeyStore) LoadZone(r io.Reader) error {
+	lines, err := zoneLines(r)
+	if err != nil {
+		return err
+	}
+	origin := ""
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if strings.EqualFold(fields[0], "$ORIGIN") && len(fields) > 1 {
+			origin = strings.TrimSuffix(fields[1], ".")
+			continue
+		}
+		txt := -1
+		for i, f := range fields {
+			if strings.EqualFold(f, "TXT") {
+				txt = i
+				break
+			}
+		}
+		if txt < 1 {
+			continue
+		}
+		name := fields[0]
+		if strings.HasSuffix(name, ".") {
+			name = strings.TrimSuffix(name, ".")
+		} else if origin != "" {
+			name = name + "." + origin
+		}
+		if !strings.Contains(strings.ToLower(name)+".", "._domainkey.") {
+			continue
+		}
+		// the record data follows the TXT field, whose text may also be
+		// part of the name
+		rdata := line
+		for _, f := range fields[:txt+1] {
+			rdata = strings.TrimLeftFunc(rdata, unicode.IsSpace)[len(f):]
+		}
+		k.records[strings.ToLower(name)] = zoneQuotedStrings(rdata)
+	}
+	return nil
+}
+
+// LoadFiles loads keys from yaml files (with a ".yaml" or ".yml" suffix)
+// or otherwise DNS zone files
+func (k *DKIMKeyStore) LoadFiles(files ...string) error {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yaml", ".yml":
+			err = k.LoadYAML(f)
+		default:
+			err = k.LoadZone(f)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("dkim key file %s error, %w", file, err)
+		}
+	}
+	return nil
+}
+
+// errNoDKIMKey is returned by a DKIMKeyStore lookup for a missing key.
+// It is reported as a temporary error, the only kind of failure which
+// dkim.Verify distinguishes from a permanent one, so that missing keys
+// can be identified; the store is the only source of keys so there are
+// no other temporary failures.
+type errNoDKIMKey string
+
+func (e errNoDKIMKey) Error() string   { return "no key for " + string(e) }
+func (e errNoDKIMKey) Timeout() bool   { return false }
+func (e errNoDKIMKey) Temporary() bool { return true }
+
+// lookupTXT looks up a key record by DNS name, in the manner of
+// net.LookupTXT
+func (k *DKIMKeyStore) lookupTXT(name string) ([]string, error) {
+	record, ok := k.records[strings.ToLower(strings.TrimSuffix(name, "."))]
+	if !ok {
+		return nil, errNoDKIMKey(name)
+	}
+	return []string{record}, nil
+}
+
+// DKIMVerification is the result of verifying the DKIM signatures of an
+// email. Result is one of dkimVerificationResults.
+type DKIMVerification struct {
+	Result  string
+	Domains []string // domains of the signatures which verified
+}
+
+// verifyDKIM verifies the DKIM signatures of a raw email using the keys
+// in the store. An email with headers which cannot be read fails.
+func (k *DKIMKeyStore) verifyDKIM(raw []byte) DKIMVerification {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT: k.lookupTXT,
+	})
+	if err != nil {
+		return DKIMVerification{Result: "fail"}
+	}
+	v := DKIMVerification{Result: "none"}
+	var failed, expired, noKey bool
+	// the expiration is only set once a signature has been checked up to
+	// its "x=" tag, which fails verification if it is in the past
+	now := time.Now()
+	for _, verification := range verifications {
+		switch {
+		case verification.Err == nil:
+			v.Domains = append(v.Domains, strings.ToLower(verification.Domain))
+		case dkim.IsTempFail(verification.Err):
+			noKey = true
+		case dkim.IsPermFail(verification.Err) && !verification.Expiration.IsZero() && verification.Expiration.Before(now):
+			expired = true
+		default:
+			failed = true
+		}
+	}
+	switch {
+	case len(v.Domains) > 0:
+		v.Result = "pass"
+	case failed:
+		v.Result = "fail"
+	case expired:
+		v.Result = "expired"
+	case noKey:
+		v.Result = "no-key"
+	}
+	return v
+}
+
+// newDKIMAnnotator records the result of verifying the DKIM signatures
+// of each email in the dkimVerificationColumn, and the domains of the
+// verified signatures in the dkimVerifiedDomainsColumn
+func newDKIMAnnotator() annotatorFunc {
+	return func(e *EmailWithSource) {
+		e.setExtra(dkimVerificationColumn, e.dkim.Result)
+		e.setExtra(dkimVerifiedDomainsColumn, strings.Join(e.dkim.Domains, "; "))
+	}
+}
+
+// newFilterByDKIM filters out emails with DKIM verification results not
+// in results
+func newFilterByDKIM(name string, results []string) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return slices.Contains(results, e.dkim.Result)
+	})
+}
@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDKIMKeyStoreLoad(t *testing.T) {
+	k := NewDKIMKeyStore()
+	if err := k.LoadFiles("testdata/dkim.zone", "testdata/dkim-keys.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.Len(), 3; got != want {
+		t.Errorf("got %d want %d keys", got, want)
+	}
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{"20230601._domainkey.example.com", "v=DKIM1; k=rsa; p=MIIBIjANBgkq"},
+		{"OLD._domainkey.example.org.", "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		{"s1._domainkey.example.net", "v=DKIM1; k=rsa; p=MIIBIjANBgkq"},
+		{"mail.example.com", ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			records, err := k.lookupTXT(tt.name)
+			if tt.record == "" {
+				if err == nil {
+					t.Fatalf("expected no key error for %s", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(records, []string{tt.record}) {
+				t.Errorf("got %v want %s", records, tt.record)
+			}
+		})
+	}
+
+	// a name containing the record type
+	if err := k.LoadZone(strings.NewReader(`TXT._domainkey.example.com. 3600 IN TXT "v=DKIM1; p=abc"`)); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := k.lookupTXT("txt._domainkey.example.com"); err != nil || !cmp.Equal(records, []string{"v=DKIM1; p=abc"}) {
+		t.Errorf("got %v %v want v=DKIM1; p=abc", records, err)
+	}
+
+	if err := k.LoadZone(strings.NewReader(`s._domainkey.example.com. IN TXT ( "p=abc"`)); err == nil {
+		t.Error("expected unbalanced parentheses error")
+	}
+	if err := k.LoadYAML(strings.NewReader(`[{selector: "s"}]`)); err == nil {
+		t.Error("expected missing domain error")
+	}
+}
+
+// signedEmail signs an email with a new ed25519 key and optional
+// expiration, returning the signed email with mbox style line endings
+// and the key record
+func signedEmail(t *testing.T, domain, selector string, expiration time.Time) ([]byte, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := strings.ReplaceAll(`From: alice@`+domain+`
+To: bob@example.com
+Subject: signed
+Date: Wed, 01 Jun 2022 10:00:00 +0000
+Message-ID: <signed@`+domain+`>
+
+Hello Bob.
+`, "\n", "\r\n")
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, strings.NewReader(email), &dkim.SignOptions{
+		Domain:     domain,
+		Selector:   selector,
+		Signer:     private,
+		Expiration: expiration,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)
+	return bytes.ReplaceAll(signed.Bytes(), []byte("\r\n"), []byte("\n")), record
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	signed, record := signedEmail(t, "smythersbrown.net", "s2022", time.Time{})
+	expired, expiredRecord := signedEmail(t, "example.org", "old", time.Now().Add(-time.Hour))
+	expiring, expiringRecord := signedEmail(t, "example.net", "new", time.Now().Add(time.Hour))
+	tampered := bytes.Replace(signed, []byte("Hello Bob."), []byte("Hello Eve."), 1)
+	tamperedExpiring := bytes.Replace(expiring, []byte("Hello Bob."), []byte("Hello Eve."), 1)
+	unsigned := []byte("From: alice@example.com\nSubject: unsigned\n\nHello.\n")
+
+	keys := NewDKIMKeyStore()
+	keys.Add("s2022", "smythersbrown.net", record)
+	keys.Add("old", "example.org", expiredRecord)
+	keys.Add("new", "example.net", expiringRecord)
+
+	tests := []struct {
+		keys  *DKIMKeyStore
+		email []byte
+		want  DKIMVerification
+	}{
+		{keys, signed, DKIMVerification{"pass", []string{"smythersbrown.net"}}},
+		{keys, tampered, DKIMVerification{"fail", nil}},
+		{keys, expired, DKIMVerification{"expired", nil}},
+		{keys, expiring, DKIMVerification{"pass", []string{"example.net"}}},
+		{keys, tamperedExpiring, DKIMVerification{"fail", nil}},
+		{NewDKIMKeyStore(), signed, DKIMVerification{"no-key", nil}},
+		{keys, unsigned, DKIMVerification{"none", nil}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got := tt.keys.verifyDKIM(tt.email)
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+			e := EmailWithSource{source: "test", dkim: got}
+			newDKIMAnnotator()(&e)
+			if got, want := e.extra[dkimVerificationColumn], tt.want.Result; got != want {
+				t.Errorf("got column %s want %s", got, want)
+			}
+			nf := newFilterByDKIM("dkim verification", []string{"pass"})
+			if got, want := accepted(nf, e), tt.want.Result == "pass"; got != want {
+				t.Errorf("got filter %t want %t", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+/*
+Package filter filters the emails in one or more mbox files, producing
+concise csv reports of the unique emails which pass filtering.
+
+A Pipeline reads emails from Sources, such as mbox files, annotating
+and filtering each email according to a Config, and the resulting
+Emails are written to a Sink, such as a CSVSink.
+
+The filters configured by NewPipeline currently are:
+
+	newFilterIP             : ensuring the sender is from a specified ip range
+	newFilterByReportDate   : within the report date
+	newFilterByHoliday      : while not on holiday
+	newFilterByWorkingHours : within working hours (optional)
+	newFilterBySender       : from specified senders only
+	newFilterByRecipient    : to specified recipients (optional)
+	newFilterByHeader       : matching arbitrary headers (optional)
+	newFilterByExpression   : matching an expression (optional)
+	newFilterByKeywords     : matching a keyword search (optional)
+	newFilterByAttachment   : with or without matching attachments (optional)
+	newFilterByClass        : of specified message classes (optional)
+	newFilterByAuth         : with specified SPF, DKIM or DMARC results (optional)
+	newFilterByDKIM         : with verified DKIM signatures (optional)
+	newFilterByExec         : accepted by an external helper process (optional)
+	newFilterByID           : a unique message id
+
+Other filters may be used by implementing the Filter interface.
+*/
+package filter
@@ -0,0 +1,61 @@
+package filter
+
+import (
+	"encoding/csv"
+	"sort"
+	"time"
+)
+
+// Emails are a collection of email headers with their mbox sources
+type Emails []EmailWithSource
+
+// NewEmails is a constructor for Emails
+func NewEmails() Emails {
+	return []EmailWithSource{}
+}
+
+// Add adds an email to the emails slice
+func (emails *Emails) Add(e EmailWithSource) {
+	*emails = append(*emails, e)
+}
+
+// Accepted returns only those emails which were not rejected by a
+// filter.
+func (e Emails) Accepted() Emails {
+	accepted := NewEmails()
+	for _, em := range e {
+		if em.rejected == "" {
+			accepted.Add(em)
+		}
+	}
+	return accepted
+}
+
+// Write writes out the emails to a csv.Writer, using a maximum subject
+// length of subjectLen (use 0 to write the whole subject) and showing
+// dates in the timezone loc. The values of any optional columns are
+// written after the standard csvHeader columns.
+func (e Emails) Write(writer *csv.Writer, subjectLen int, loc *time.Location, columns ...string) error {
+	e.SortByDate()
+	return e.WriteSink(NewCSVSink(writer, subjectLen, loc, columns...))
+}
+
+// SortByDate sorts the emails by date, keeping emails with the same date
+// in their current order
+func (e Emails) SortByDate() {
+	sort.SliceStable(e,
+		func(i, j int) bool {
+			return e[i].Date.Before(e[j].Date)
+		})
+}
+
+// WriteSink writes out the emails, in their current order, to a Sink,
+// closing it after the last email.
+func (e Emails) WriteSink(s Sink) error {
+	for _, em := range e {
+		if err := s.Write(em); err != nil {
+			return err
+		}
+	}
+	return s.Close()
+}
@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+// EmailWithSource represents the headers of an email with their mbox
+// source
+type EmailWithSource struct {
+	email.Headers
+	source   string            // source mbox
+	sender   string            // resolved sender address, see resolveSender
+	rejected string            // name of the filter rejecting the email, if any
+	extra    map[string]string // optional report column values by column name
+	bodies   []string          // body texts for searching, only set while filtering
+	// attachments, only set if attachments are parsed
+	attachments []Attachment
+	// dkim verification result, only set if verifying dkim signatures
+	dkim DKIMVerification
+	tags []string // tags, such as "custodian:alice", see AddTag
+}
+
+// TagsColumn is the optional report column showing the tags of an
+// email. It is added to the Pipeline Columns if the Config tags emails,
+// and should otherwise be added if other filters add tags.
+const TagsColumn = "tags"
+
+var csvHeader = []string{"date", "from", "subj", "source", "id", "received"}
+
+// Source returns the name of the source of the email
+func (e EmailWithSource) Source() string {
+	return e.source
+}
+
+// Rejected returns the name of the filter rejecting the email, or an
+// empty string if the email was accepted
+func (e EmailWithSource) Rejected() string {
+	return e.rejected
+}
+
+// AddTag tags the email, for example with "custodian:alice", without
+// excluding it, ignoring duplicate and empty tags. Filters may tag the
+// emails they evaluate. Tags are shown in the TagsColumn.
+func (e *EmailWithSource) AddTag(tag string) {
+	if tag == "" || slices.Contains(e.tags, tag) {
+		return
+	}
+	e.tags = append(e.tags, tag)
+	e.setExtra(TagsColumn, strings.Join(e.tags, "; "))
+}
+
+// Tags returns the tags of the email
+func (e EmailWithSource) Tags() []string {
+	return e.tags
+}
+
+// HasTag reports if the email has a tag
+func (e EmailWithSource) HasTag(tag string) bool {
+	return slices.Contains(e.tags, tag)
+}
+
+// Extra returns the value of an optional report column, such as
+// "class", or an empty string if it is not set
+func (e EmailWithSource) Extra(column string) string {
+	return e.extra[column]
+}
+
+func (e EmailWithSource) subj(n int) string {
+	if n == 0 || len(e.Subject) < n {
+		return e.Subject
+	}
+	return e.Subject[:n]
+}
+
+// setExtra sets the value of an optional report column
+func (e *EmailWithSource) setExtra(column, value string) {
+	if e.extra == nil {
+		e.extra = map[string]string{}
+	}
+	e.extra[column] = value
+}
+
+// forCSV returns the csv record for an email, with the date in the
+// timezone loc and the values of any optional columns appended in the
+// order provided.
+func (e EmailWithSource) forCSV(subjLen int, loc *time.Location, columns ...string) []string {
+	dater := e.Date.In(loc).Format("2006-01-02")
+	record := []string{
+		dater,
+		e.senderAddress(),
+		e.subj(subjLen),
+		e.source,
+		string(e.MessageID),
+		strings.Join(e.Headers.Received, " "),
+	}
+	for _, c := range columns {
+		record = append(record, e.extra[c])
+	}
+	return record
+}
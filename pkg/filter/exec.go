@@ -0,0 +1,276 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// exec filter defaults
+const (
+	defaultExecTimeout     = 10 * time.Second
+	defaultExecConcurrency = 1
+	execStopTimeout        = 5 * time.Second // grace period for helpers to exit
+)
+
+// ExecFilter describes a filter run by a long-running helper process,
+// such as a Python or Rust programme, started with Command. For each
+// email the helper is sent one line of json describing the email's
+// headers (an execRequest) on its stdin, and should reply with one line
+// of json (an execResponse) on its stdout with its decision and
+// optional tags. Up to Concurrency helpers are run, each of which has
+// Timeout to reply before it is killed and processing stops.
+type ExecFilter struct {
+	Name        string
+	Command     []string
+	Timeout     time.Duration
+	Concurrency int
+}
+
+func (x ExecFilter) String() string {
+	return fmt.Sprintf("%s (%s) timeout %s concurrency %d", x.Name, strings.Join(x.Command, " "), x.Timeout, x.Concurrency)
+}
+
+// execRequest is the json line describing an email sent to a helper
+type execRequest struct {
+	Source     string              `json:"source"`
+	MessageID  string              `json:"messageId"`
+	Date       time.Time           `json:"date"`
+	Sender     string              `json:"sender"`
+	From       []string            `json:"from"`
+	To         []string            `json:"to"`
+	Cc         []string            `json:"cc"`
+	Bcc        []string            `json:"bcc"`
+	Subject    string              `json:"subject"`
+	InReplyTo  []string            `json:"inReplyTo"`
+	References []string            `json:"references"`
+	Received   []string            `json:"received"`
+	Headers    map[string][]string `json:"headers"` // other headers
+	Columns    map[string]string   `json:"columns"` // optional report columns set so far
+	Tags       []string            `json:"tags"`    // tags set so far
+}
+
+// execResponse is the json line replied by a helper. Decision is
+// "accept" or "reject"; an Error stops processing.
+type execResponse struct {
+	Decision string   `json:"decision"`
+	Tags     []string `json:"tags"`
+	Error    string   `json:"error"`
+}
+
+// newExecRequest makes the execRequest for an email
+func newExecRequest(e EmailWithSource) execRequest {
+	return execRequest{
+		Source:     e.source,
+		MessageID:  e.MessageID,
+		Date:       e.Date,
+		Sender:     e.senderAddress(),
+		From:       addressStrings(e.From),
+		To:         addressStrings(e.To),
+		Cc:         addressStrings(e.Cc),
+		Bcc:        addressStrings(e.Bcc),
+		Subject:    e.Subject,
+		InReplyTo:  e.InReplyTo,
+		References: e.References,
+		Received:   e.Received,
+		Headers:    e.ExtraHeaders,
+		Columns:    e.extra,
+		Tags:       e.tags,
+	}
+}
+
+// execWorker is a helper process, which is started on first use and
+// restarted after a failed exchange
+type execWorker struct {
+	command []string
+	cmd     *exec.Cmd
+	stdin   *os.File
+	stdout  *os.File
+	reader  *bufio.Reader
+}
+
+// start starts the helper, connected by pipes which support deadlines
+func (w *execWorker) start() error {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return err
+	}
+	cmd := exec.Command(w.command[0], w.command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = inR, outW, os.Stderr
+	err = cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return fmt.Errorf("exec filter start error, %w", err)
+	}
+	w.cmd, w.stdin, w.stdout, w.reader = cmd, inW, outR, bufio.NewReader(outR)
+	return nil
+}
+
+// kill kills the helper, which is restarted on next use
+func (w *execWorker) kill() {
+	if w.cmd == nil {
+		return
+	}
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+	w.stdin.Close()
+	w.stdout.Close()
+	w.cmd = nil
+}
+
+// stop asks the helper to exit by closing its stdin, killing it if it
+// has not exited within execStopTimeout
+func (w *execWorker) stop() error {
+	if w.cmd == nil {
+		return nil
+	}
+	w.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- w.cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(execStopTimeout):
+		w.cmd.Process.Kill()
+		err = <-done
+	}
+	w.stdout.Close()
+	w.cmd = nil
+	return err
+}
+
+// exchange sends a request line to the helper and reads its reply
+// line, within timeout. The helper is killed if the exchange fails, as
+// its replies may no longer match its requests.
+func (w *execWorker) exchange(ctx context.Context, request []byte, timeout time.Duration) ([]byte, error) {
+	if w.cmd == nil {
+		if err := w.start(); err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	w.stdin.SetWriteDeadline(deadline)
+	w.stdout.SetReadDeadline(deadline)
+	// interrupt the exchange if ctx is cancelled
+	stopInterrupt := context.AfterFunc(ctx, func() {
+		w.stdin.SetWriteDeadline(time.Now())
+		w.stdout.SetReadDeadline(time.Now())
+	})
+	defer stopInterrupt()
+
+	fail := func(err error) ([]byte, error) {
+		w.kill()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("exec filter timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("exec filter error, %w", err)
+	}
+	if _, err := w.stdin.Write(append(request, '\n')); err != nil {
+		return fail(err)
+	}
+	reply, err := w.reader.ReadBytes('\n')
+	if err != nil {
+		return fail(err)
+	}
+	return reply, nil
+}
+
+// execFilter is a Filter running emails through a pool of helpers
+type execFilter struct {
+	config  ExecFilter
+	workers chan *execWorker
+}
+
+// newFilterByExec filters emails by the decisions of the helpers
+// described by an ExecFilter, recording any tags they return in the
+// tags of the email. Helpers are started on first use and should be
+// stopped with Close.
+func newFilterByExec(x ExecFilter) *execFilter {
+	f := execFilter{
+		config:  x,
+		workers: make(chan *execWorker, x.Concurrency),
+	}
+	for range x.Concurrency {
+		f.workers <- &execWorker{command: x.Command}
+	}
+	return &f
+}
+
+// Name returns the name of the filter
+func (f *execFilter) Name() string {
+	return f.config.Name
+}
+
+// Describe describes the filter
+func (f *execFilter) Describe() string {
+	return f.config.String()
+}
+
+// Evaluate sends the email to an idle helper, waiting for one to be
+// available, and returns its decision.
+func (f *execFilter) Evaluate(ctx context.Context, e *EmailWithSource) (Decision, error) {
+	request, err := json.Marshal(newExecRequest(*e))
+	if err != nil {
+		return Reject, fmt.Errorf("exec filter request error, %w", err)
+	}
+	var w *execWorker
+	select {
+	case w = <-f.workers:
+	case <-ctx.Done():
+		return Reject, ctx.Err()
+	}
+	reply, err := w.exchange(ctx, request, f.config.Timeout)
+	f.workers <- w
+	if err != nil {
+		return Reject, err
+	}
+
+	var response execResponse
+	if err := json.Unmarshal(reply, &response); err != nil {
+		return Reject, fmt.Errorf("exec filter reply error, %w", err)
+	}
+	if response.Error != "" {
+		return Reject, fmt.Errorf("exec filter reported error, %s", response.Error)
+	}
+	for _, t := range response.Tags {
+		e.AddTag(t)
+	}
+	switch response.Decision {
+	case "accept":
+		return Accept, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Reject, fmt.Errorf("exec filter decision %q should be accept or reject", response.Decision)
+}
+
+// Close stops the helpers, waiting for any in use
+func (f *execFilter) Close() error {
+	var errs []error
+	for range f.config.Concurrency {
+		w := <-f.workers
+		if err := w.stop(); err != nil {
+			errs = append(errs, fmt.Errorf("exec filter %q stop error, %w", f.config.Name, err))
+		}
+		f.workers <- w
+	}
+	return errors.Join(errs...)
+}
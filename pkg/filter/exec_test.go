@@ -0,0 +1,116 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+// TestExecHelper is run as the helper process of the exec filter tests,
+// replying to each request according to its subject
+func TestExecHelper(t *testing.T) {
+	if os.Getenv("EXEC_FILTER_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var r execRequest
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			fmt.Printf(`{"error": %q}`+"\n", err)
+			continue
+		}
+		switch r.Subject {
+		case "sleep":
+			time.Sleep(time.Second)
+			fmt.Println(`{"decision": "accept"}`)
+		case "error":
+			fmt.Println(`{"error": "cannot classify"}`)
+		case "invalid":
+			fmt.Println(`{"decision": "maybe"}`)
+		case "reject":
+			fmt.Println(`{"decision": "reject"}`)
+		default:
+			fmt.Printf(`{"decision": "accept", "tags": ["from:%s"]}`+"\n", strings.Join(r.From, ","))
+		}
+	}
+	os.Exit(0)
+}
+
+func TestExecFilter(t *testing.T) {
+	t.Setenv("EXEC_FILTER_HELPER", "1")
+	nf := newFilterByExec(ExecFilter{
+		Name:        "helper",
+		Command:     []string{os.Args[0], "-test.run=^TestExecHelper$"},
+		Timeout:     200 * time.Millisecond,
+		Concurrency: 2,
+	})
+	defer func() {
+		if err := nf.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	tests := []struct {
+		subject  string
+		decision Decision
+		tags     string
+		isErr    bool
+	}{
+		{subject: "hello", decision: Accept, tags: "from:alice@example.com"},
+		{subject: "reject", decision: Reject},
+		{subject: "error", isErr: true},
+		{subject: "invalid", isErr: true},
+		{subject: "sleep", isErr: true},
+		// the helper is restarted after timing out
+		{subject: "hello again", decision: Accept, tags: "from:alice@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			e := EmailWithSource{Headers: email.Headers{Subject: tt.subject}}
+			e.From = []*mail.Address{{Address: "alice@example.com"}}
+			got, err := nf.Evaluate(context.Background(), &e)
+			if tt.isErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				fmt.Println(err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.decision {
+				t.Errorf("got %s want %s", got, tt.decision)
+			}
+			if got, want := strings.Join(e.Tags(), "; "), tt.tags; got != want {
+				t.Errorf("got tags %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExecFilterStartError(t *testing.T) {
+	nf := newFilterByExec(ExecFilter{
+		Name:        "missing",
+		Command:     []string{"testdata/no-such-helper"},
+		Timeout:     time.Second,
+		Concurrency: 1,
+	})
+	e := EmailWithSource{}
+	if _, err := nf.Evaluate(context.Background(), &e); err == nil {
+		t.Fatal("expected start error")
+	}
+	if err := nf.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,146 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net/textproto"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ExpressionFilter describes a filter accepting emails for which an
+// expression in the expr language (https://expr-lang.org) is true, such
+// as
+//
+//	sender endsWith "@example.com" and date.Hour() >= 18
+//
+// The expression is evaluated against an exprEnv, and is compiled and
+// type-checked by NewExpressionFilter.
+type ExpressionFilter struct {
+	Name       string
+	Expression string
+	Location   *time.Location // timezone of the date
+	program    *vm.Program
+}
+
+func (x ExpressionFilter) String() string {
+	return fmt.Sprintf("%s (%s)", x.Name, x.Expression)
+}
+
+// exprEnv is the environment of filter expressions. Addresses are
+// lists of bare addresses such as "alice@example.com". The date is in
+// the report timezone. header returns the first value of a header not
+// otherwise parsed, such as header("List-Id"), or an empty string.
+type exprEnv struct {
+	Source     string              `expr:"source"`
+	MessageID  string              `expr:"messageId"`
+	Date       time.Time           `expr:"date"`
+	Subject    string              `expr:"subject"`
+	Sender     string              `expr:"sender"`
+	From       []string            `expr:"from"`
+	ReplyTo    []string            `expr:"replyTo"`
+	To         []string            `expr:"to"`
+	Cc         []string            `expr:"cc"`
+	Bcc        []string            `expr:"bcc"`
+	InReplyTo  []string            `expr:"inReplyTo"`
+	References []string            `expr:"references"`
+	Received   []string            `expr:"received"`
+	Hops       []ReceivedHop       `expr:"hops"`
+	Headers    map[string][]string `expr:"headers"`
+	Columns    map[string]string   `expr:"columns"` // optional report columns set so far
+	Tags       []string            `expr:"tags"`    // tags set so far
+	Header     func(string) string `expr:"header"`
+}
+
+// newExprEnv makes the exprEnv for an email, with dates in loc
+func newExprEnv(e EmailWithSource, loc *time.Location) exprEnv {
+	headers := e.ExtraHeaders
+	if headers == nil {
+		headers = map[string][]string{}
+	}
+	columns := e.extra
+	if columns == nil {
+		columns = map[string]string{}
+	}
+	hops := e.ReceivedHops()
+	for i := range hops {
+		hops[i].Date = hops[i].Date.In(loc)
+	}
+	return exprEnv{
+		Source:     e.source,
+		MessageID:  e.MessageID,
+		Date:       e.Date.In(loc),
+		Subject:    e.Subject,
+		Sender:     e.senderAddress(),
+		From:       addressStrings(e.From),
+		ReplyTo:    addressStrings(e.ReplyTo),
+		To:         addressStrings(e.To),
+		Cc:         addressStrings(e.Cc),
+		Bcc:        addressStrings(e.Bcc),
+		InReplyTo:  e.InReplyTo,
+		References: e.References,
+		Received:   e.Received,
+		Hops:       hops,
+		Headers:    headers,
+		Columns:    columns,
+		Tags:       e.tags,
+		Header: func(name string) string {
+			if v := headers[textproto.CanonicalMIMEHeaderKey(name)]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		},
+	}
+}
+
+// NewExpressionFilter makes an ExpressionFilter, compiling the
+// expression and checking that it is a valid boolean expression over
+// the fields of exprEnv.
+func NewExpressionFilter(name, expression string, loc *time.Location) (*ExpressionFilter, error) {
+	program, err := expr.Compile(expression, expr.Env(exprEnv{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("expression filter %q error, %w", name, err)
+	}
+	return &ExpressionFilter{
+		Name:       name,
+		Expression: expression,
+		Location:   loc,
+		program:    program,
+	}, nil
+}
+
+// newFilterByExpression filters emails by an ExpressionFilter.
+// Expressions which fail to run, for example by indexing past the end
+// of a list, report an error.
+func newFilterByExpression(x ExpressionFilter) Filter {
+	return expressionFilter{x: x}
+}
+
+// expressionFilter is the Filter for an ExpressionFilter
+type expressionFilter struct {
+	x ExpressionFilter
+}
+
+// Name returns the name of the filter
+func (f expressionFilter) Name() string {
+	return f.x.Name
+}
+
+// Describe describes the filter
+func (f expressionFilter) Describe() string {
+	return f.x.Expression
+}
+
+// Evaluate accepts the email if the expression is true
+func (f expressionFilter) Evaluate(_ context.Context, e *EmailWithSource) (Decision, error) {
+	result, err := vm.Run(f.x.program, newExprEnv(*e, f.x.Location))
+	if err != nil {
+		return Reject, fmt.Errorf("expression error, %w", err)
+	}
+	if result.(bool) {
+		return Accept, nil
+	}
+	return Reject, nil
+}
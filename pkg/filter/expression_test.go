@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestExpressionFilter(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := EmailWithSource{
+		Headers: email.Headers{
+			Date:    time.Date(2023, 8, 8, 17, 30, 0, 0, time.UTC),
+			Subject: "Re: share purchase",
+			From:    []*mail.Address{{Address: "alice@counsel.example.com"}},
+			To:      []*mail.Address{{Address: "bob@example.com"}, {Address: "carol@example.com"}},
+			Received: []string{
+				"from mx.example.net ([192.0.2.1]) by mail.example.com with esmtps id abc for bob@example.com; Tue, 08 Aug 2023 17:30:10 +0000",
+				"from laptop ([10.1.99.2]) by mx.example.net with esmtpa id def; Tue, 08 Aug 2023 17:30:05 +0000",
+			},
+			ExtraHeaders: map[string][]string{"List-Id": {"<legal.example.com>"}},
+		},
+		source: "alice.mbox",
+	}
+
+	tests := []struct {
+		expression string
+		ok         bool
+	}{
+		{`sender endsWith "@counsel.example.com"`, true},
+		// 17:30 UTC is 18:30 in London in August
+		{`date.Hour() >= 18`, true},
+		{`date.Weekday().String() == "Tuesday"`, true},
+		{`len(to) == 2 and "carol@example.com" in to`, true},
+		{`subject matches "(?i)^re:"`, true},
+		{`any(hops, .ip startsWith "10.1.99.")`, true},
+		{`hops[0].by == "mail.example.com" and hops[-1].with == "esmtpa"`, true},
+		{`hops[0].date.Sub(hops[1].date).Seconds() > 60`, false},
+		{`header("list-id") contains "legal"`, true},
+		{`header("X-Mailer") != ""`, false},
+		{`source == "bob.mbox"`, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			x, err := NewExpressionFilter("expression", tt.expression, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := accepted(newFilterByExpression(*x), e), tt.ok; got != want {
+				t.Errorf("%s got %t want %t", tt.expression, got, want)
+			}
+		})
+	}
+}
+
+func TestExpressionFilterFail(t *testing.T) {
+	for _, expression := range []string{
+		`subject + 1`,          // not boolean
+		`sender == 1`,          // mismatched types
+		`recipient == "bob"`,   // unknown field
+		`date.Hour() >= `,      // syntax error
+		`hops[0].host == "mx"`, // unknown hop field
+	} {
+		_, err := NewExpressionFilter("expression", expression, time.UTC)
+		if err == nil {
+			t.Errorf("expected error for %s", expression)
+			continue
+		}
+		fmt.Println(err)
+	}
+
+	// runtime errors are reported by the filter
+	x, err := NewExpressionFilter("expression", `hops[0].by == "mx"`, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := EmailWithSource{}
+	if _, err := newFilterByExpression(*x).Evaluate(context.Background(), &e); err == nil {
+		t.Error("expected runtime error for email without hops")
+	}
+}
@@ -0,0 +1,425 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of a Filter evaluating an email
+type Decision int
+
+const (
+	Accept Decision = iota
+	Reject
+)
+
+func (d Decision) String() string {
+	if d == Accept {
+		return "accept"
+	}
+	return "reject"
+}
+
+// Filter is implemented by filters of emails. Name identifies the
+// filter, and is recorded on the emails it rejects and used for stats.
+// Evaluate decides if an email passes the filter; an error stops
+// processing. Evaluate is called concurrently for emails from different
+// sources, so filters keeping state between emails, such as the
+// duplicate id filter, must synchronise access to it.
+//
+// A Filter may optionally implement Describer, to describe its
+// configuration, and Resetter, to reset any state kept between emails.
+type Filter interface {
+	Name() string
+	Evaluate(ctx context.Context, e *EmailWithSource) (Decision, error)
+}
+
+// Describer is implemented by filters which can describe their
+// configuration
+type Describer interface {
+	Describe() string
+}
+
+// Resetter is implemented by filters keeping state between emails,
+// such as the duplicate id filter, which can be reset to process a new
+// set of emails
+type Resetter interface {
+	Reset()
+}
+
+// funcFilter is a Filter named name accepting emails for which fn is
+// true, as used by most of the filters in this package
+type funcFilter struct {
+	name string
+	fn   func(EmailWithSource) bool
+}
+
+// newFilter makes a funcFilter
+func newFilter(name string, fn func(EmailWithSource) bool) Filter {
+	return funcFilter{name: name, fn: fn}
+}
+
+// Name returns the name of the filter
+func (f funcFilter) Name() string {
+	return f.name
+}
+
+// Evaluate accepts the email if fn is true
+func (f funcFilter) Evaluate(_ context.Context, e *EmailWithSource) (Decision, error) {
+	if f.fn(*e) {
+		return Accept, nil
+	}
+	return Reject, nil
+}
+
+// annotatorFunc sets optional report columns on an email
+type annotatorFunc func(*EmailWithSource)
+
+// Filters contains a set of filters for excluding email headers from
+// consideration together with stats on both ok emails and those that
+// have been filtered out by any filter (identified by name). Annotators
+// may be added to set optional report columns on each email before
+// filtering. The tags of accepted emails are also counted.
+//
+// Filter may be called concurrently, and the stats are safe to read
+// with Stats while emails are being filtered.
+type Filters struct {
+	filters    []Filter
+	annotators []annotatorFunc
+	start      time.Time // start of processing
+
+	mu       sync.Mutex // guards the stats
+	stats    map[string]int
+	tagStats map[string]int
+	sources  map[string]*SourceStats
+	done     int       // the number of sources processed, which may share names
+	last     time.Time // time of the last stats recorded
+}
+
+// NewFilters makes a Filters from filters, which are applied in order
+func NewFilters(filters ...Filter) *Filters {
+	return &Filters{
+		filters:  filters,
+		stats:    map[string]int{"ok": 0},
+		tagStats: map[string]int{},
+		sources:  map[string]*SourceStats{},
+		start:    time.Now(),
+	}
+}
+
+// record counts an email, by its source, as rejected by the filter
+// recorded on it or otherwise as accepted, counting the tags of
+// accepted emails
+func (f *Filters) record(e *EmailWithSource) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sourceStats(e.source).addEmail(e.Date, e.rejected)
+	f.last = time.Now()
+	if e.rejected != "" {
+		f.stats[e.rejected]++
+		return
+	}
+	f.stats["ok"]++
+	for _, t := range e.tags {
+		f.tagStats[t]++
+	}
+}
+
+// AddAnnotators adds annotators to be run on each email before
+// filtering.
+func (f *Filters) AddAnnotators(funcs ...annotatorFunc) {
+	f.annotators = append(f.annotators, funcs...)
+}
+
+// Filter annotates an EmailWithSource and filters it through each
+// filter exiting on first rejection, recording the name of the
+// rejecting filter on the email, or falling through to "ok". An error
+// from a filter is returned, naming the filter. The email is counted in
+// the stats before Filter returns. This function is designed for
+// concurrent access.
+func (f *Filters) Filter(ctx context.Context, e *EmailWithSource) (bool, error) {
+	for _, fn := range f.annotators {
+		fn(e)
+	}
+	for _, fl := range f.filters {
+		d, err := fl.Evaluate(ctx, e)
+		if err != nil {
+			return false, fmt.Errorf("filter %q error, %w", fl.Name(), err)
+		}
+		if d == Reject {
+			e.rejected = fl.Name()
+			f.record(e)
+			return false, nil
+		}
+	}
+	f.record(e)
+	return true, nil
+}
+
+// Reset resets the state of any filters implementing Resetter
+func (f *Filters) Reset() {
+	for _, fl := range f.filters {
+		if r, ok := fl.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+// Close closes any filters implementing io.Closer, such as exec filters
+// running helper processes
+func (f *Filters) Close() error {
+	var errs []error
+	for _, fl := range f.filters {
+		if c, ok := fl.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Describe describes each filter in order, by name and, for filters
+// implementing Describer, their configuration
+func (f *Filters) Describe() string {
+	var s string
+	for _, fl := range f.filters {
+		if d, ok := fl.(Describer); ok {
+			s += fmt.Sprintf("%-30s: %s\n", fl.Name(), d.Describe())
+			continue
+		}
+		s += fmt.Sprintf("%-30s\n", fl.Name())
+	}
+	return s
+}
+
+// Stats shows how many times particular filters or the fallthrough "ok"
+// condition have been called during processing of emails in mboxes,
+// followed by the number of accepted emails with each tag.
+func (f *Filters) Stats() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.filterStats() + f.tagStatsString()
+}
+
+// tagStatsString shows the number of accepted emails with each tag, if
+// any
+func (f *Filters) tagStatsString() string {
+	if len(f.tagStats) == 0 {
+		return ""
+	}
+	tags := []string{}
+	for k := range f.tagStats {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+	s := "\ntags \n"
+	for _, k := range tags {
+		s += fmt.Sprintf("%-30s: %4d\n", k, f.tagStats[k])
+	}
+	return s
+}
+
+// filterStats shows the filter stats
+func (f *Filters) filterStats() string {
+	tpl := "%-30s: %4d\n"
+	t := fmt.Sprintf(tpl, "OK", f.stats["ok"])
+	t += fmt.Sprintf("%-30s: %s\n\n", "time processing", time.Since(f.start))
+
+	var statString string
+	names := []string{}
+	for k := range f.stats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if k == "ok" {
+			continue
+		}
+		v := f.stats[k]
+		statString += fmt.Sprintf(tpl, k, v)
+	}
+	if statString == "" {
+		return t + fmt.Sprintf(tpl, "skipped", 0)
+	}
+	return t + "skipped \n" + statString
+}
+
+// newFilterByID filters out emails not matching the provided IP fragment
+func newFilterIP(name string, ipFragment string) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return strings.Contains(strings.Join(e.Headers.Received, " "), ipFragment)
+	})
+}
+
+// inPeriod reports if t is between start and end, including the
+// boundaries if inclusive is true
+func inPeriod(t, start, end time.Time, inclusive bool) bool {
+	if inclusive {
+		return !t.Before(start) && !t.After(end)
+	}
+	return t.After(start) && t.Before(end)
+}
+
+// newFilterByReportDate filters out emails outside of the report
+// period, which includes its boundaries if inclusive is true. The
+// report period should be set in the configured timezone (see
+// Config.Location) so that emails are evaluated against the local start
+// and end of each day rather than UTC midnight.
+func newFilterByReportDate(name string, reportStart, reportEnd time.Time, inclusive bool) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return inPeriod(e.Date, reportStart, reportEnd, inclusive)
+	})
+}
+
+// Holiday is a holiday type for use in config and newFilterByHoliday
+type Holiday struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (h Holiday) String() string {
+	return fmt.Sprintf("%s : %s", formatDateTime(h.Start), formatDateTime(h.End))
+}
+
+// newFilterByHoliday filters out emails during holiday periods, which
+// include their boundaries if inclusive is true. As for
+// newFilterByReportDate, holidays should be set in the configured
+// timezone.
+func newFilterByHoliday(name string, holidays []Holiday, inclusive bool) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		for _, h := range holidays {
+			if inPeriod(e.Date, h.Start, h.End, inclusive) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// newFilterBySender filters out emails from non matching senders. A
+// sender is valid if it matches validSenderRegexp or is in the allow
+// list, unless it is in the deny list. Any of validSenderRegexp, allow
+// and deny may be nil; if both validSenderRegexp and allow are nil all
+// senders not in the deny list are valid. Emails without any sender
+// (see resolveSender) are invalid.
+func newFilterBySender(name string, validSenderRegexp *regexp.Regexp, allow, deny *AddressList) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		sender := e.senderAddress()
+		if sender == "" {
+			return false
+		}
+		if deny != nil && deny.Contains(sender) {
+			return false
+		}
+		if validSenderRegexp == nil && allow == nil {
+			return true
+		}
+		if validSenderRegexp != nil && validSenderRegexp.MatchString(sender) {
+			return true
+		}
+		return allow != nil && allow.Contains(sender)
+	})
+}
+
+// headerMatchModes are the modes of a HeaderFilter
+var headerMatchModes = []string{"exists", "matches", "not-matches"}
+
+// parsedHeaders are the headers, by canonical name, which letters parses
+// into fields of email.Headers and so are never in ExtraHeaders
+var parsedHeaders = []string{
+	"Date", "Sender", "From", "Reply-To", "To", "Cc", "Bcc",
+	"Message-Id", "In-Reply-To", "References", "Received",
+	"Subject", "Comments", "Keywords",
+	"Resent-Date", "Resent-From", "Resent-Sender", "Resent-To",
+	"Resent-Cc", "Resent-Bcc", "Resent-Message-Id",
+	"Content-Transfer-Encoding", "Content-Type", "Content-Disposition",
+}
+
+// HeaderFilter describes a filter on an arbitrary header found in the
+// email.Headers ExtraHeaders map, such as List-Id or X-Mailer, which
+// cannot be one of parsedHeaders. The Mode is one of headerMatchModes:
+//
+//	exists      : the header is present
+//	matches     : any value of the header matches Regexp
+//	not-matches : no value of the header matches Regexp, including
+//	              when the header is not present
+type HeaderFilter struct {
+	Name   string
+	Header string // canonical header name
+	Mode   string
+	Regexp *regexp.Regexp
+}
+
+func (h HeaderFilter) String() string {
+	return fmt.Sprintf("%s (%s %s %v)", h.Name, h.Header, h.Mode, h.Regexp)
+}
+
+// newFilterByHeader filters emails by the presence or values of a
+// header, as described by a HeaderFilter
+func newFilterByHeader(h HeaderFilter) Filter {
+	regexps := []*regexp.Regexp{h.Regexp}
+	return newFilter(h.Name, func(e EmailWithSource) bool {
+		values, ok := e.ExtraHeaders[h.Header]
+		if h.Mode == "exists" {
+			return ok
+		}
+		matches := false
+		for _, v := range values {
+			if matchesAny(v, regexps) {
+				matches = true
+				break
+			}
+		}
+		return matches == (h.Mode == "matches") // otherwise not-matches
+	})
+}
+
+// idFilter is a Filter rejecting emails with an id already seen, which
+// is safe for concurrent use
+type idFilter struct {
+	name   string
+	mu     sync.Mutex // guards idHash
+	idHash map[string]struct{}
+}
+
+// newFilterByID filters out emails with duplicate IDs
+func newFilterByID(name string) *idFilter {
+	return &idFilter{name: name, idHash: map[string]struct{}{}}
+}
+
+// Name returns the name of the filter
+func (f *idFilter) Name() string {
+	return f.name
+}
+
+// Evaluate rejects emails with an id already seen. Of emails with the
+// same id filtered concurrently, only one is accepted.
+func (f *idFilter) Evaluate(_ context.Context, e *EmailWithSource) (Decision, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.idHash[e.MessageID]; ok {
+		return Reject, nil
+	}
+	f.idHash[e.MessageID] = struct{}{}
+	return Accept, nil
+}
+
+// Describe describes the filter
+func (f *idFilter) Describe() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fmt.Sprintf("%d ids seen", len(f.idHash))
+}
+
+// Reset forgets the ids seen
+func (f *idFilter) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idHash = map[string]struct{}{}
+}
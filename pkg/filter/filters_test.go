@@ -0,0 +1,375 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+// accepted reports if a filter accepts an email, treating an error as
+// a rejection
+func accepted(f Filter, e EmailWithSource) bool {
+	d, err := f.Evaluate(context.Background(), &e)
+	return err == nil && d == Accept
+}
+
+func TestReceivedFilter(t *testing.T) {
+
+	nf := newFilterIP("ip filter", "10.99.1.")
+
+	tests := []struct {
+		received string
+		ok       bool
+	}{
+		{
+			received: "from 23.188.159.143.dyn.plus.net ([10.99.1.23] helo=robertosmith-t470s) by smythersbrown.net with esmtpsa (TLS1.3:ECDHE_RSA_AES_256_GCM_SHA384:256) (Exim 97.65) (envelope-from <robertosmith@smythersbrown.net>) id 1lpydR-0001ll-Ru; Sun, 06 Jun 2021 19:40:37 +0000",
+			ok:       true,
+		},
+		{
+			received: "by smythersbrown.net with esmtpsa (TLS1.3:ECDHE_RSA_AES_256_GCM_SHA384:256) (Exim 97.65) (envelope-from <robertosmith@smythersbrown.net>) id 1lpydR-0001ll-Ru; Sun, 06 Jun 2021 19:40:37 +0000 from 23.188.159.143.dyn.plus.net ([10.99.1.23] helo=robertosmith-t470s)",
+			ok:       true,
+		},
+		{
+			received: "from [217.138.52.158] (helo=robertosmith-t470s) by smythersbrown.net with esmtpsa  (TLS1.3) tls TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 (Exim 4.94.2) (envelope-from <robertosmith@smythersbrown.net>) id 1o15hj-003Xom-Ud; Tue, 14 Jun 2022 12:31:31 +0000 from robertosmith by robertosmith-t470s with local (Exim 4.94.2) (envelope-from <robertosmith@robertosmith-t470s>) id 1o15hj-003dRx-5f; Tue, 14 Jun 2022 13:31:31 +0100",
+			ok:       false,
+		},
+		{
+			received: "from x.y.z.dyn.plus.net ([142.159.188.23] helo=fail) by smythersbrown.net with esmtpsa (TLS1.3:ECDHE_RSA_AES_256_GCM_SHA384:256) (Exim 97.65) (envelope-from <robertosmith@smythersbrown.net>) id 1lpydR-0001ll-Ru; Sun, 06 Jun 2021 19:40:37 +0000",
+			ok:       false,
+		},
+		{
+			received: "from 155.178.125.91.dyn.plus.net ([91.125.178.155] helo=smtpclient.apple) by smythersbrown.net with esmtpsa  (TLS1.3) tls TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Exim 4.96) (envelope-from <robertosmith@smythersbrown.net>) id 1snuhs-004Id5-2D; Tue, 10 Sep 2024 06:50:32 +0000",
+			ok:       false,
+		},
+		{
+			received: "from 92.40.177.66.threembb.co.uk ([92.40.177.66] helo=smtpclient.apple) by smythersbrown.net with esmtpsa  (TLS1.3) tls TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Exim 4.94.2) (envelope-from <robertosmith@smythersbrown.net>) id 1oY83v-00Fl82-HI for savimbi@smythersbrown.net; Tue, 13 Sep 2022 15:42:59 +0000",
+			ok:       false,
+		},
+		{
+			// studios ip range
+			received: "Received: from 155.178.125.91.dyn.plus.net ([91.125.178.155] helo=smtpclient.apple) by smythersbrown.net with esmtpsa  (TLS1.2) tls TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 (Exim 4.96) (envelope-from <savimbi@smythersbrown.net>) id 1sp75q-004VlS-2e for robertosmith@smythersbrown.net; Fri, 13 Sep 2024 14:16:15 +0000",
+			ok:       false,
+		},
+	}
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.Received = []string{tt.received}
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("received %s\ngot %t want %t", tt.received, got, want)
+			}
+		})
+	}
+}
+
+func TestSenderFilter(t *testing.T) {
+
+	nf := newFilterBySender("sender filter", regexp.MustCompile("(?i)(isadorax|robertosmith|smythersbrown)"), nil, nil)
+
+	for i, tt := range []struct {
+		address string
+		ok      bool
+	}{
+		{"J.Barrage@camden.co.uk", false},
+		{"a.nother.notaire@xxx-notaires.fr", false},
+		{"savimbi@smythersbrown.net", true},
+		{"isadoraxcampbelllange@gmail.com", true},
+		{"IZASMYTHERSBROWN@GMAIL.COM", true},
+		{"isadoraxclhome@gmail.com", true},
+		{"isadoraxclwork@gmail.com", true},
+		{"bob@smythersbrown.net", true},
+		{"noxious@smythersbrown.net", true},
+		{"JoannaDaly@tfl.gov.uk", false},
+		{"maja@allbuildingcontrol.com", false},
+		{"robertosmith@smythersbrown.net", true},
+		{"boB@smythersbrown.net", true},
+		{"robertosmith@codata.ltd", true},
+		{"robertosmith@CODATA.ltd", true},
+		{"sid@bobthebuilder.com", false},
+		{"sto@smythersbrown.net", true},
+	} {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.From = []*mail.Address{&mail.Address{Address: tt.address}}
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("for %s got %t want %t", tt.address, got, want)
+			}
+		})
+	}
+}
+
+func TestHolidaysFilter(t *testing.T) {
+	holidayStrings := []Holiday{
+		Holiday{
+			time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2021, 8, 3, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	nf := newFilterByHoliday("holiday filter", holidayStrings, false)
+
+	// don't use time.Local otherwise there might be a UTC offset
+	// problem eg with British Summer Time
+	beforeDate := time.Date(2021, 7, 30, 0, 0, 0, 0, time.UTC)
+	onDate1 := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	okDate := time.Date(2021, 8, 2, 0, 0, 0, 0, time.UTC)
+	onDate2 := time.Date(2021, 8, 3, 0, 0, 0, 0, time.UTC)
+	afterDate := time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		date time.Time
+		ok   bool
+	}{
+		{beforeDate, true},
+		{onDate1, true},
+		{okDate, false},
+		{onDate2, true},
+		{afterDate, true},
+	}
+
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.Date = tt.date
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t != want %t for %s", got, want, tt.date)
+			}
+		})
+	}
+}
+
+func TestReportDateFilter(t *testing.T) {
+
+	reportStart := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	reportEnd := time.Date(2021, 8, 3, 0, 0, 0, 0, time.UTC)
+
+	nf := newFilterByReportDate("report date filter", reportStart, reportEnd, false)
+
+	// don't use time.Local otherwise there might be a UTC offset
+	// problem eg with British Summer Time
+	beforeDate := time.Date(2021, 7, 30, 0, 0, 0, 0, time.UTC)
+	okDate := time.Date(2021, 8, 2, 0, 0, 0, 0, time.UTC)
+	afterDate := time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		date time.Time
+		ok   bool
+	}{
+		{beforeDate, false},
+		{okDate, true},
+		{afterDate, false},
+	}
+
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.Date = tt.date
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t != want %t for %s", got, want, tt.date)
+			}
+		})
+	}
+}
+
+func TestIDFilter(t *testing.T) {
+
+	nf := newFilterByID("id filter")
+	tests := []struct {
+		id string
+		ok bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false},
+		{"c", true},
+		{"b", false},
+	}
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.MessageID = tt.id
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t != want %t for %s", got, want, string(tt.id))
+			}
+		})
+	}
+
+}
+
+func TestInclusiveDateFilters(t *testing.T) {
+
+	start := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 8, 3, 12, 30, 0, 0, time.UTC)
+
+	reportFilter := newFilterByReportDate("report date filter", start, end, true)
+	holidayFilter := newFilterByHoliday("holiday filter", []Holiday{{start, end}}, true)
+
+	tests := []struct {
+		date     time.Time
+		inPeriod bool
+	}{
+		{time.Date(2021, 7, 31, 23, 59, 59, 0, time.UTC), false},
+		{start, true},
+		{time.Date(2021, 8, 2, 0, 0, 0, 0, time.UTC), true},
+		{end, true},
+		{time.Date(2021, 8, 3, 12, 30, 1, 0, time.UTC), false},
+	}
+
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.Date = tt.date
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if got, want := accepted(reportFilter, e), tt.inPeriod; got != want {
+				t.Errorf("report got %t != want %t for %s", got, want, tt.date)
+			}
+			if got, want := accepted(holidayFilter, e), !tt.inPeriod; got != want {
+				t.Errorf("holiday got %t != want %t for %s", got, want, tt.date)
+			}
+		})
+	}
+}
+
+func TestHeaderFilter(t *testing.T) {
+
+	for i, tt := range []struct {
+		mode    string
+		regexp  string
+		headers map[string][]string
+		ok      bool
+	}{
+		{"exists", "", map[string][]string{"List-Id": {"<news.example.com>"}}, true},
+		{"exists", "", map[string][]string{"X-Mailer": {"mailer"}}, false},
+		{"matches", "(?i)NEWS", map[string][]string{"List-Id": {"<news.example.com>"}}, true},
+		{"matches", "(?i)NEWS", map[string][]string{"List-Id": {"<other>", "<news>"}}, true},
+		{"matches", "(?i)NEWS", map[string][]string{"List-Id": {"<staff.example.com>"}}, false},
+		{"matches", "(?i)NEWS", map[string][]string{}, false},
+		{"not-matches", "(?i)NEWS", map[string][]string{"List-Id": {"<news.example.com>"}}, false},
+		{"not-matches", "(?i)NEWS", map[string][]string{"List-Id": {"<staff.example.com>"}}, true},
+		{"not-matches", "(?i)NEWS", map[string][]string{}, true},
+	} {
+		h := HeaderFilter{Name: "header filter", Header: "List-Id", Mode: tt.mode}
+		if tt.regexp != "" {
+			h.Regexp = regexp.MustCompile(tt.regexp)
+		}
+		nf := newFilterByHeader(h)
+		e := EmailWithSource{Headers: email.Headers{ExtraHeaders: tt.headers}, source: "test"}
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.ok; got != want {
+				t.Errorf("got %t want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestIDFilterReset(t *testing.T) {
+	nf := newFilterByID("duplicate id")
+	e := EmailWithSource{Headers: email.Headers{MessageID: "abc@example.com"}}
+	for i, want := range []bool{true, false} {
+		if got := accepted(nf, e); got != want {
+			t.Errorf("%d got %t want %t", i, got, want)
+		}
+	}
+	if got, want := nf.Describe(), "1 ids seen"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	nf.Reset()
+	if !accepted(nf, e) {
+		t.Error("expected email to be accepted after reset")
+	}
+}
+
+func TestIDFilterConcurrent(t *testing.T) {
+	nf := newFilterByID("duplicate id")
+	const workers, ids = 16, 100
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	acceptedIDs := map[string]int{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ids {
+				e := EmailWithSource{Headers: email.Headers{MessageID: fmt.Sprintf("%d@example.com", i)}}
+				if accepted(nf, e) {
+					mu.Lock()
+					acceptedIDs[e.MessageID]++
+					mu.Unlock()
+				}
+				_ = nf.Describe()
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := len(acceptedIDs), ids; got != want {
+		t.Errorf("got %d ids accepted want %d", got, want)
+	}
+	for id, n := range acceptedIDs {
+		if n != 1 {
+			t.Errorf("id %s accepted %d times", id, n)
+		}
+	}
+	if got, want := nf.Describe(), fmt.Sprintf("%d ids seen", ids); got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+// TestFiltersConcurrent processes many mboxes concurrently, reading
+// the stats while processing, and is intended to be run with the race
+// detector (see the test-race Makefile target)
+func TestFiltersConcurrent(t *testing.T) {
+	config := testConfig(t, `
+tagRules:
+  - tag: "golang"
+    expression: 'sender endsWith "@golang.org"'
+`)
+
+	// golang.mbox has 2 emails and gonuts.mbox 1, all with different ids
+	const copies = 32
+	paths := []string{}
+	for range copies {
+		paths = append(paths, "testdata/golang.mbox", "testdata/gonuts.mbox")
+	}
+	p := testPipeline(t, config, NewMboxFiles(paths...)...)
+
+	done := make(chan struct{})
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = p.Filters.Stats()
+				_ = p.Filters.Describe()
+			}
+		}
+	}()
+	emails, err := p.Collect(context.Background())
+	close(done)
+	<-statsDone
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(emails), 3; got != want {
+		t.Errorf("got %d emails want %d", got, want)
+	}
+	stats := p.Filters.Stats()
+	for _, want := range []string{
+		fmt.Sprintf("%-30s: %4d\n", "OK", 3),
+		fmt.Sprintf("%-30s: %4d\n", "duplicate id", copies*3-3),
+		fmt.Sprintf("%-30s: %4d\n", "golang", 3),
+	} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("stats missing %q\n%s", want, stats)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupByKeys are the keys by which reports may be grouped
+var groupByKeys = []string{"sender", "tag", "source", "month"}
+
+// untaggedGroup is the group of emails without tags when grouping by
+// tag
+const untaggedGroup = "untagged"
+
+// maxOpenGroupReports is the maximum number of group reports kept open
+// at once; the least recently used report is closed to open another,
+// and is reopened to append to it
+const maxOpenGroupReports = 128
+
+// groupIndexHeader is the header of the index of grouped reports
+var groupIndexHeader = []string{"group", "report", "emails", "sha256"}
+
+// groups returns the groups of an email when grouping by the key by,
+// with months in the timezone loc. An email has several groups if it
+// has several tags.
+func (e EmailWithSource) groups(by string, loc *time.Location) []string {
+	switch by {
+	case "sender":
+		return []string{strings.ToLower(e.senderAddress())}
+	case "tag":
+		if len(e.tags) == 0 {
+			return []string{untaggedGroup}
+		}
+		return e.tags
+	case "source":
+		return []string{e.source}
+	}
+	return []string{e.Date.In(loc).Format("2006-01")} // month
+}
+
+// unsafeFileChars matches characters not used in report file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._@+-]+`)
+
+// ReportGroup describes the report for a group of emails
+type ReportGroup struct {
+	Group  string
+	Path   string
+	Emails int
+	SHA256 string   // the sum of the report, set once it is closed
+	file   *os.File // nil if the report is not open
+	hash   hash.Hash
+	sink   *CSVSink
+	used   int // the order of last use, to close the least recently used
+}
+
+// open opens the report, creating it on first use and otherwise
+// appending to it, writing to both the file and the running sum
+func (r *ReportGroup) open(create bool) error {
+	flags := os.O_WRONLY | os.O_APPEND
+	if create {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(r.Path, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("group report error, %w", err)
+	}
+	r.file = f
+	r.sink.writer = csv.NewWriter(io.MultiWriter(f, r.hash))
+	return nil
+}
+
+// close flushes and closes the report file
+func (r *ReportGroup) close() error {
+	r.sink.writer.Flush()
+	err := errors.Join(r.sink.writer.Error(), r.file.Close())
+	r.file = nil
+	return err
+}
+
+// GroupedCSVSink is a Sink writing a csv report for each group of
+// emails, grouped by one of groupByKeys, followed by an index of the
+// reports with the number of emails in each and their sha256 sums.
+// Reports are named from a path prefix, the key and the group, such as
+// "report-sender-alice@example.com.csv", and the index from the prefix,
+// such as "report-index.csv". Existing files are not overwritten.
+type GroupedCSVSink struct {
+	By         string
+	Prefix     string
+	subjectLen int
+	loc        *time.Location
+	columns    []string
+	groups     map[string]*ReportGroup
+	paths      map[string]struct{}
+	open       int // the number of open reports
+	uses       int // the number of writes, to order report use
+}
+
+// NewGroupedCSVSink makes a GroupedCSVSink grouping emails by the key
+// by, writing reports as described by NewCSVSink, with months in the
+// timezone loc.
+func NewGroupedCSVSink(by, prefix string, subjectLen int, loc *time.Location, columns ...string) (*GroupedCSVSink, error) {
+	if !slices.Contains(groupByKeys, by) {
+		return nil, fmt.Errorf("group by %q not one of %v", by, groupByKeys)
+	}
+	return &GroupedCSVSink{
+		By:         by,
+		Prefix:     prefix,
+		subjectLen: subjectLen,
+		loc:        loc,
+		columns:    columns,
+		groups:     map[string]*ReportGroup{},
+		paths:      map[string]struct{}{},
+	}, nil
+}
+
+// IndexPath returns the path of the index of the reports
+func (g *GroupedCSVSink) IndexPath() string {
+	return g.Prefix + "index.csv"
+}
+
+// reportPath returns an unused path for the report of a group, adding
+// a number to distinguish groups with the same safe file name. Reports
+// of sources are named from the base name of the source, without its
+// extension.
+func (g *GroupedCSVSink) reportPath(group string) string {
+	if g.By == "source" {
+		group = strings.TrimSuffix(filepath.Base(group), filepath.Ext(group))
+	}
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(group, "_"), "_.")
+	if name == "" {
+		name = "none"
+	}
+	base := fmt.Sprintf("%s%s-%s", g.Prefix, g.By, name)
+	path := base + ".csv"
+	for i := 2; ; i++ {
+		if _, ok := g.paths[path]; !ok {
+			break
+		}
+		path = base + "-" + strconv.Itoa(i) + ".csv"
+	}
+	g.paths[path] = struct{}{}
+	return path
+}
+
+// group returns the open report for a group, creating it if necessary
+func (g *GroupedCSVSink) group(name string) (*ReportGroup, error) {
+	g.uses++
+	r, ok := g.groups[name]
+	if ok && r.file != nil {
+		r.used = g.uses
+		return r, nil
+	}
+	if g.open >= maxOpenGroupReports {
+		if err := g.closeLeastRecent(); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		r = &ReportGroup{Group: name, Path: g.reportPath(name), hash: sha256.New()}
+		r.sink = NewCSVSink(nil, g.subjectLen, g.loc, g.columns...)
+	}
+	if err := r.open(!ok); err != nil {
+		return nil, err
+	}
+	g.groups[name] = r
+	g.open++
+	r.used = g.uses
+	return r, nil
+}
+
+// closeLeastRecent closes the least recently used open report
+func (g *GroupedCSVSink) closeLeastRecent() error {
+	var lru *ReportGroup
+	for _, r := range g.groups {
+		if r.file != nil && (lru == nil || r.used < lru.used) {
+			lru = r
+		}
+	}
+	if lru == nil {
+		return nil
+	}
+	g.open--
+	if err := lru.close(); err != nil {
+		return fmt.Errorf("group report error, %w", err)
+	}
+	return nil
+}
+
+// Write writes an email to the report of each of its groups
+func (g *GroupedCSVSink) Write(e EmailWithSource) error {
+	for _, name := range e.groups(g.By, g.loc) {
+		r, err := g.group(name)
+		if err != nil {
+			return err
+		}
+		if err := r.sink.Write(e); err != nil {
+			return err
+		}
+		r.Emails++
+	}
+	return nil
+}
+
+// Close closes the reports, recording their sums, and writes the index
+func (g *GroupedCSVSink) Close() error {
+	var errs []error
+	for _, r := range g.groups {
+		// reports are created on first write, with their header
+		if r.file != nil {
+			errs = append(errs, r.close())
+		}
+		r.SHA256 = hex.EncodeToString(r.hash.Sum(nil))
+	}
+	g.open = 0
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("group report error, %w", err)
+	}
+	return g.writeIndex()
+}
+
+// Groups returns the reports, sorted by group
+func (g *GroupedCSVSink) Groups() []*ReportGroup {
+	groups := []*ReportGroup{}
+	for _, r := range g.groups {
+		groups = append(groups, r)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Group < groups[j].Group
+	})
+	return groups
+}
+
+// writeIndex writes the index of the reports
+func (g *GroupedCSVSink) writeIndex() error {
+	f, err := os.OpenFile(g.IndexPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("group index error, %w", err)
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	if err := writer.Write(groupIndexHeader); err != nil {
+		return fmt.Errorf("group index writing error, %w", err)
+	}
+	for _, r := range g.Groups() {
+		record := []string{r.Group, filepath.Base(r.Path), strconv.Itoa(r.Emails), r.SHA256}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("group index writing error, %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("group index writing error, %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+// readCSV reads all the records of a csv file
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestGroupedCSVSink(t *testing.T) {
+	newEmail := func(from, source string, date time.Time, tags ...string) EmailWithSource {
+		e := EmailWithSource{
+			Headers: email.Headers{Date: date, From: []*mail.Address{{Address: from}}},
+			source:  source,
+		}
+		for _, tag := range tags {
+			e.AddTag(tag)
+		}
+		return e
+	}
+	aug := time.Date(2023, 8, 31, 23, 30, 0, 0, time.UTC)
+	sep := time.Date(2023, 9, 2, 0, 0, 0, 0, time.UTC)
+	emails := Emails{
+		newEmail("Alice@example.com", "mboxes/alice.mbox", aug, "custodian:alice", "privileged"),
+		newEmail("bob@example.com", "mboxes/bob.mbox", aug),
+		newEmail("alice@example.com", "mboxes/alice.mbox", sep, "custodian:alice"),
+		newEmail("alice@example.com", "archive/alice.mbox", sep, "custodian:alice"),
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		by     string
+		groups map[string][2]string // group: report, emails
+	}{
+		{"sender", map[string][2]string{
+			"alice@example.com": {"report-sender-alice@example.com.csv", "3"},
+			"bob@example.com":   {"report-sender-bob@example.com.csv", "1"},
+		}},
+		{"tag", map[string][2]string{
+			"custodian:alice": {"report-tag-custodian_alice.csv", "3"},
+			"privileged":      {"report-tag-privileged.csv", "1"},
+			"untagged":        {"report-tag-untagged.csv", "1"},
+		}},
+		// sources with the same base name are grouped apart
+		{"source", map[string][2]string{
+			"mboxes/alice.mbox":  {"report-source-alice.csv", "2"},
+			"mboxes/bob.mbox":    {"report-source-bob.csv", "1"},
+			"archive/alice.mbox": {"report-source-alice-2.csv", "1"},
+		}},
+		// 23:30 UTC on 31 August is in September in London
+		{"month", map[string][2]string{
+			"2023-09": {"report-month-2023-09.csv", "4"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := NewGroupedCSVSink(tt.by, filepath.Join(dir, "report-"), 0, london)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := emails.WriteSink(sink); err != nil {
+				t.Fatal(err)
+			}
+			index := readCSV(t, sink.IndexPath())
+			if got, want := len(index), len(tt.groups)+1; got != want {
+				t.Fatalf("got %d want %d index records\n%v", got, want, index)
+			}
+			for _, record := range index[1:] {
+				want, ok := tt.groups[record[0]]
+				if !ok {
+					t.Errorf("unexpected group %s", record[0])
+					continue
+				}
+				if got := [2]string{record[1], record[2]}; got != want {
+					t.Errorf("got %v want %v", got, want)
+				}
+				contents, err := os.ReadFile(filepath.Join(dir, record[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(contents)
+				if got, want := record[3], hex.EncodeToString(sum[:]); got != want {
+					t.Errorf("got sum %s want %s", got, want)
+				}
+				if got, want := fmt.Sprint(len(readCSV(t, filepath.Join(dir, record[1])))-1), record[2]; got != want {
+					t.Errorf("got %s want %s records in %s", got, want, record[1])
+				}
+			}
+		})
+	}
+
+	if _, err := NewGroupedCSVSink("custodian", "report-", 0, time.UTC); err == nil {
+		t.Error("expected group by error")
+	}
+}
+
+// TestGroupedCSVSinkReopen checks that reports closed to limit the
+// number of open files are appended to when reopened
+func TestGroupedCSVSinkReopen(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewGroupedCSVSink("sender", filepath.Join(dir, "r-"), 0, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senders := maxOpenGroupReports + 10
+	for round := range 3 {
+		for i := range senders {
+			e := EmailWithSource{Headers: email.Headers{
+				Date:      time.Date(2023, 1, 1+round, 0, 0, 0, 0, time.UTC),
+				From:      []*mail.Address{{Address: fmt.Sprintf("s%03d@example.com", i)}},
+				MessageID: fmt.Sprintf("%d-%d@example.com", round, i),
+			}}
+			if err := sink.Write(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range sink.Groups() {
+		if got, want := len(readCSV(t, r.Path)), 4; got != want {
+			t.Fatalf("got %d want %d records in %s", got, want, r.Path)
+		}
+		contents, err := os.ReadFile(r.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(contents)
+		if got, want := r.SHA256, hex.EncodeToString(sum[:]); got != want {
+			t.Fatalf("got sum %s want %s for %s", got, want, r.Path)
+		}
+	}
+	if got, want := len(sink.Groups()), senders; got != want {
+		t.Errorf("got %d want %d groups", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// icalDateTimes parses the possibly comma separated values of a date or
+// datetime property, such as EXDATE or RDATE.
+func icalDateTimes(prop ical.Prop, loc *time.Location) ([]time.Time, error) {
+	times := []time.Time{}
+	for _, v := range strings.Split(prop.Value, ",") {
+		p := prop
+		p.Value = strings.TrimSpace(v)
+		t, err := p.DateTime(loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// icalOccurrences returns the start times of an event between from and
+// to, expanding any recurrence rule.
+func icalOccurrences(event ical.Event, start time.Time, loc *time.Location, from, to time.Time) ([]time.Time, error) {
+	roption, err := event.Props.RecurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+	if roption == nil {
+		if start.After(to) || start.Before(from) {
+			return nil, nil
+		}
+		return []time.Time{start}, nil
+	}
+	roption.Dtstart = start
+	rule, err := rrule.NewRRule(*roption)
+	if err != nil {
+		return nil, fmt.Errorf("recurrence rule error, %w", err)
+	}
+	set := rrule.Set{}
+	set.RRule(rule)
+	set.DTStart(start)
+	for _, prop := range event.Props.Values(ical.PropExceptionDates) {
+		exdates, err := icalDateTimes(prop, loc)
+		if err != nil {
+			return nil, fmt.Errorf("exception date error, %w", err)
+		}
+		for _, t := range exdates {
+			set.ExDate(t)
+		}
+	}
+	for _, prop := range event.Props.Values(ical.PropRecurrenceDates) {
+		rdates, err := icalDateTimes(prop, loc)
+		if err != nil {
+			return nil, fmt.Errorf("recurrence date error, %w", err)
+		}
+		for _, t := range rdates {
+			set.RDate(t)
+		}
+	}
+	return set.Between(from, to, true), nil
+}
+
+// holidaysFromICS reads the events of an iCalendar stream as Holiday
+// periods. All-day events and datetimes without a timezone are read in
+// loc. Recurring events are expanded for occurrences overlapping the
+// period from and to, normally the report period. Cancelled events are
+// ignored.
+//
+// iCalendar event ends are exclusive, so if the holidays are to be
+// evaluated inclusively the end of each holiday is brought forward by
+// a nanosecond.
+func holidaysFromICS(r io.Reader, loc *time.Location, from, to time.Time, inclusive bool) ([]Holiday, error) {
+	cal, err := ical.NewDecoder(r).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("calendar decoding error, %w", err)
+	}
+	holidays := []Holiday{}
+	for _, event := range cal.Events() {
+		if status, _ := event.Props.Text(ical.PropStatus); strings.EqualFold(status, string(ical.EventCancelled)) {
+			continue
+		}
+		startProp := event.Props.Get(ical.PropDateTimeStart)
+		if startProp == nil {
+			uid, _ := event.Props.Text(ical.PropUID)
+			return nil, fmt.Errorf("event %q has no start", uid)
+		}
+		allDay := startProp.ValueType() == ical.ValueDate || len(startProp.Value) == len("20060102")
+		start, err := event.DateTimeStart(loc)
+		if err != nil {
+			return nil, fmt.Errorf("event start error, %w", err)
+		}
+		end, err := event.DateTimeEnd(loc)
+		if err != nil {
+			return nil, fmt.Errorf("event end error, %w", err)
+		}
+
+		// calculate the end of each occurrence of the event by calendar
+		// days for all-day events, so that daylight saving changes are
+		// accounted for
+		days := 0
+		if allDay {
+			days = int(end.Sub(start).Round(24*time.Hour) / (24 * time.Hour))
+			if days < 1 {
+				days = 1
+			}
+		}
+		duration := end.Sub(start)
+		occurrenceEnd := func(t time.Time) time.Time {
+			if allDay {
+				return t.AddDate(0, 0, days)
+			}
+			return t.Add(duration)
+		}
+
+		// include occurrences starting before the period but ending
+		// within it
+		occurrences, err := icalOccurrences(event, start, loc, from.Add(-duration), to)
+		if err != nil {
+			return nil, fmt.Errorf("event %s error, %w", start.Format(time.RFC3339), err)
+		}
+		for _, o := range occurrences {
+			h := Holiday{Start: o, End: occurrenceEnd(o)}
+			if inclusive {
+				h.End = h.End.Add(-time.Nanosecond)
+			}
+			holidays = append(holidays, h)
+		}
+	}
+	return holidays, nil
+}
+
+// holidaysFromICSFile reads holidays from an iCalendar file using
+// holidaysFromICS
+func holidaysFromICSFile(file string, loc *time.Location, from, to time.Time, inclusive bool) ([]Holiday, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	holidays, err := holidaysFromICS(f, loc, from, to, inclusive)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return holidays, nil
+}
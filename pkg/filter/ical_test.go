@@ -0,0 +1,62 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHolidaysFromICS(t *testing.T) {
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, london)
+	to := time.Date(2022, 12, 31, 0, 0, 0, 0, london)
+	d := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2022, month, day, hour, min, 0, 0, london)
+	}
+
+	holidays, err := holidaysFromICSFile("testdata/leave.ics", london, from, to, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Holiday{
+		{d(3, 21, 0, 0), d(3, 26, 0, 0)},
+		{d(4, 5, 14, 0), d(4, 5, 16, 30)},
+		// fortnightly recurrence with the 15 April excluded
+		{d(4, 1, 0, 0), d(4, 2, 0, 0)},
+		{d(4, 29, 0, 0), d(4, 30, 0, 0)},
+		{d(5, 13, 0, 0), d(5, 14, 0, 0)},
+	}
+	if !cmp.Equal(holidays, want) {
+		t.Errorf("holidays unexpected %s", cmp.Diff(holidays, want))
+	}
+
+	// inclusive holidays end just before the exclusive iCalendar end
+	holidays, err = holidaysFromICSFile("testdata/leave.ics", london, from, to, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := holidays[0].End, d(3, 26, 0, 0).Add(-time.Nanosecond); !got.Equal(want) {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	// only occurrences overlapping the period are included
+	holidays, err = holidaysFromICSFile("testdata/leave.ics", london, d(4, 20, 0, 0), to, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(holidays), 2; got != want {
+		t.Errorf("got %d want %d holidays: %v", got, want, holidays)
+	}
+}
+
+func TestHolidaysFromICSFail(t *testing.T) {
+	_, err := holidaysFromICSFile("testdata/golang.mbox", time.UTC, time.Time{}, time.Now(), false)
+	if err == nil {
+		t.Fatal("expected calendar decoding error")
+	}
+}
@@ -0,0 +1,453 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// keywordHitsColumn and keywordTermsColumn are the optional report
+// columns showing the number of keyword hits in an email and the terms
+// which matched
+const (
+	keywordHitsColumn  = "keyword hits"
+	keywordTermsColumn = "keyword terms"
+)
+
+// keywordsTag is the tag of emails matching a keyword search with the
+// tag action
+const keywordsTag = "keywords"
+
+// keywordFields are the parts of an email which may be searched
+var keywordFields = []string{"subject", "body"}
+
+// KeywordSearch describes a keyword query over the subject and bodies of
+// emails. A query is made up of:
+//
+//	merger             a word, matched case-insensitively
+//	acqui*             a word prefix
+//	"share purchase"   a phrase
+//	"share purchase"~3 a proximity search for the words of the phrase
+//	                   in order with up to 3 other words between them
+//	a AND b, a b       both terms
+//	a OR b             either term
+//	NOT a              emails without the term
+//	( ... )            grouping
+//
+// AND binds more tightly than OR. Phrases do not match across the
+// subject and body, or across the text and html body parts.
+type KeywordSearch struct {
+	Query  string
+	Fields []string // fields to search from keywordFields
+	Tag    bool     // tag emails with their hits rather than exclude those without
+	query  queryNode
+}
+
+func (k KeywordSearch) String() string {
+	return fmt.Sprintf("%s (%s) tag %t", k.Query, strings.Join(k.Fields, ","), k.Tag)
+}
+
+// NewKeywordSearch parses a keyword query
+func NewKeywordSearch(query string, fields []string, tag bool) (*KeywordSearch, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("keyword query %q error, %w", query, err)
+	}
+	if len(fields) == 0 {
+		fields = keywordFields
+	}
+	return &KeywordSearch{Query: query, Fields: fields, Tag: tag, query: q}, nil
+}
+
+// keywordDocument is the searchable text of an email, held as the word
+// positions of each field
+type keywordDocument []map[string][]int
+
+// words splits text into lower case words of letters and digits
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// newKeywordDocument makes a keywordDocument from the text of one or
+// more fields
+func newKeywordDocument(texts ...string) keywordDocument {
+	d := keywordDocument{}
+	for _, t := range texts {
+		positions := map[string][]int{}
+		for i, w := range words(t) {
+			positions[w] = append(positions[w], i)
+		}
+		d = append(d, positions)
+	}
+	return d
+}
+
+// positions returns the sorted positions of a word, or of all words
+// with the prefix if prefix is true, in field i of the document
+func (d keywordDocument) positions(i int, word string, prefix bool) []int {
+	if !prefix {
+		return d[i][word]
+	}
+	found := []int{}
+	for w, p := range d[i] {
+		if strings.HasPrefix(w, word) {
+			found = append(found, p...)
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+// queryNode is a node of a parsed keyword query
+type queryNode interface {
+	// match reports if the node matches the document, returning the
+	// number of hits and recording the hits of each matched term in terms
+	match(d keywordDocument, terms map[string]int) (bool, int)
+}
+
+// phraseNode matches a word, word prefix or phrase, with up to slop
+// words between each word of the phrase
+type phraseNode struct {
+	term   string // the term as written in the query
+	words  []string
+	prefix bool // the last word is a prefix
+	slop   int
+}
+
+func (p phraseNode) match(d keywordDocument, terms map[string]int) (bool, int) {
+	n := 0
+	for i := range d {
+		lists := make([][]int, len(p.words))
+		for j, w := range p.words {
+			lists[j] = d.positions(i, w, p.prefix && j == len(p.words)-1)
+		}
+	starts:
+		for _, start := range lists[0] {
+			last, gaps := start, 0
+			for _, list := range lists[1:] {
+				k := sort.SearchInts(list, last+1)
+				if k == len(list) {
+					break starts // no later occurrence for any start
+				}
+				gaps += list[k] - last - 1
+				if gaps > p.slop {
+					continue starts
+				}
+				last = list[k]
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		terms[p.term] += n
+	}
+	return n > 0, n
+}
+
+// andNode matches if all of its nodes match
+type andNode []queryNode
+
+func (a andNode) match(d keywordDocument, terms map[string]int) (bool, int) {
+	found := map[string]int{}
+	n := 0
+	for _, q := range a {
+		ok, h := q.match(d, found)
+		if !ok {
+			return false, 0
+		}
+		n += h
+	}
+	for t, h := range found {
+		terms[t] += h
+	}
+	return true, n
+}
+
+// orNode matches if any of its nodes match
+type orNode []queryNode
+
+func (o orNode) match(d keywordDocument, terms map[string]int) (bool, int) {
+	matched, n := false, 0
+	for _, q := range o {
+		ok, h := q.match(d, terms)
+		matched = matched || ok
+		n += h
+	}
+	return matched, n
+}
+
+// notNode matches, without hits, if its node does not match
+type notNode struct {
+	node queryNode
+}
+
+func (n notNode) match(d keywordDocument, terms map[string]int) (bool, int) {
+	ok, _ := n.node.match(d, map[string]int{})
+	return !ok, 0
+}
+
+// queryParser is a recursive descent parser of keyword queries
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokeniseQuery splits a query into parentheses, quoted phrases
+// (including any "~N" proximity suffix) and words
+func tokeniseQuery(query string) ([]string, error) {
+	tokens := []string{}
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		switch {
+		case unicode.IsSpace(r[i]):
+			i++
+		case r[i] == '(' || r[i] == ')':
+			tokens = append(tokens, string(r[i]))
+			i++
+		case r[i] == '"':
+			j := i + 1
+			for j < len(r) && r[j] != '"' {
+				j++
+			}
+			if j == len(r) {
+				return nil, errors.New("unterminated phrase")
+			}
+			j++
+			if j < len(r) && r[j] == '~' {
+				j++
+				for j < len(r) && unicode.IsDigit(r[j]) {
+					j++
+				}
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		default:
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) && !strings.ContainsRune(`()"`, r[j]) {
+				j++
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// parseQuery parses a keyword query
+func parseQuery(query string) (queryNode, error) {
+	tokens, err := tokeniseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty query")
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// peek returns the next token, or an empty string at the end of the
+// query
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	nodes := orNode{}
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	nodes := andNode{}
+	for {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		next := p.peek()
+		if next == "AND" {
+			p.pos++
+			continue
+		}
+		if next == "" || next == "OR" || next == ")" {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peek() == "NOT" {
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *queryParser) parseTerm() (queryNode, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "":
+		return nil, errors.New("unexpected end of query")
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("unexpected %q", token)
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	}
+	node := phraseNode{term: token}
+	text := token
+	if strings.HasPrefix(token, `"`) {
+		end := strings.LastIndex(token, `"`)
+		if slop := token[end+1:]; slop != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(slop, "~"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid proximity in %s", token)
+			}
+			node.slop = n
+		}
+		text = token[1:end]
+	}
+	if strings.HasSuffix(text, "*") {
+		node.prefix = true
+		text = strings.TrimSuffix(text, "*")
+	}
+	node.words = words(text)
+	if len(node.words) == 0 {
+		return nil, fmt.Errorf("term %s has no words", token)
+	}
+	return node, nil
+}
+
+// htmlToText extracts the text of an html document, skipping scripts
+// and styles
+func htmlToText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String() // io.EOF or malformed html
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			skip = string(name) == "script" || string(name) == "style"
+		case html.EndTagToken:
+			skip = false
+			b.WriteString(" ")
+		case html.TextToken:
+			if !skip {
+				b.Write(z.Text())
+				b.WriteString(" ")
+			}
+		}
+	}
+}
+
+// document returns the keywordDocument of the fields of an email to be
+// searched
+func (k KeywordSearch) document(e EmailWithSource) keywordDocument {
+	texts := []string{}
+	for _, f := range k.Fields {
+		switch f {
+		case "subject":
+			texts = append(texts, e.Subject)
+		case "body":
+			texts = append(texts, e.bodies...)
+		}
+	}
+	return newKeywordDocument(texts...)
+}
+
+// search reports if an email matches the query, returning the number
+// of hits and the sorted matched terms. A query such as "NOT merger"
+// may match without hits.
+func (k KeywordSearch) search(e EmailWithSource) (bool, int, []string) {
+	terms := map[string]int{}
+	ok, n := k.query.match(k.document(e), terms)
+	if !ok {
+		return false, 0, nil
+	}
+	matched := []string{}
+	for t := range terms {
+		matched = append(matched, t)
+	}
+	sort.Strings(matched)
+	return true, n, matched
+}
+
+// newKeywordAnnotator records the number of keyword hits and the
+// matched terms of an email in the keywordHitsColumn and
+// keywordTermsColumn. Emails which do not match have empty columns.
+// Matching emails are tagged with keywordsTag if k.Tag is true.
+func newKeywordAnnotator(k KeywordSearch) annotatorFunc {
+	return func(e *EmailWithSource) {
+		ok, n, terms := k.search(*e)
+		if !ok {
+			e.setExtra(keywordHitsColumn, "")
+			e.setExtra(keywordTermsColumn, "")
+			return
+		}
+		e.setExtra(keywordHitsColumn, strconv.Itoa(n))
+		e.setExtra(keywordTermsColumn, strings.Join(terms, "; "))
+		if k.Tag {
+			e.AddTag(keywordsTag)
+		}
+	}
+}
+
+// newFilterByKeywords filters out emails not matching the keyword
+// query. The filter relies on the results of the keyword annotator,
+// which is run before the filters.
+func newFilterByKeywords(name string) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return e.extra[keywordHitsColumn] != ""
+	})
+}
@@ -0,0 +1,119 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rorycl/letters/email"
+)
+
+func TestParseQueryFail(t *testing.T) {
+	for i, q := range []string{
+		"",
+		`"share purchase`,
+		"(merger OR acquisition",
+		"merger AND",
+		"OR merger",
+		"merger)",
+		`"share purchase"~x`,
+		"NOT",
+		"***",
+	} {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if _, err := parseQuery(q); err == nil {
+				t.Errorf("expected error for query %q", q)
+			}
+		})
+	}
+}
+
+func TestKeywordSearch(t *testing.T) {
+	subject := "Project Falcon: share purchase agreement"
+	body := "Please find the draft share and asset purchase agreement attached. The acquisition closes Friday."
+	html := "<html><style>p {color: merger}</style><p>Newsletter: <b>Merger</b> news</p></html>"
+
+	tests := []struct {
+		query  string
+		fields []string
+		ok     bool
+		hits   int
+		terms  []string
+	}{
+		{"merger", nil, true, 1, []string{"merger"}},
+		{"MERGER", []string{"subject"}, false, 0, nil},
+		{"acqui*", nil, true, 1, []string{"acqui*"}},
+		{`"share purchase"`, nil, true, 1, []string{`"share purchase"`}},
+		{`"share purchase"~3`, nil, true, 2, []string{`"share purchase"~3`}},
+		{`"purchase share"~3`, nil, false, 0, nil},
+		{`"agreement please"`, nil, false, 0, nil},
+		{"falcon agreement", nil, true, 3, []string{"agreement", "falcon"}},
+		{"falcon AND disposal", nil, false, 0, nil},
+		{"falcon OR disposal", nil, true, 1, []string{"falcon"}},
+		{"(disposal OR acquisition) AND NOT newsletter", nil, false, 0, nil},
+		{"(disposal OR acquisition) AND NOT newsletter", []string{"subject"}, false, 0, nil},
+		{"(disposal OR acquisition) NOT newsletter", []string{"body"}, false, 0, nil},
+		{"acquisition NOT color", nil, true, 1, []string{"acquisition"}},
+		{"NOT disposal", nil, true, 0, []string{}},
+		{"draft OR falcon AND disposal", nil, true, 1, []string{"draft"}},
+	}
+	e := EmailWithSource{
+		Headers: email.Headers{Subject: subject},
+		source:  "test",
+		bodies:  []string{body, htmlToText(html)},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			k, err := NewKeywordSearch(tt.query, tt.fields, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, hits, terms := k.search(e)
+			if got, want := ok, tt.ok; got != want {
+				t.Fatalf("%s got %t want %t", tt.query, got, want)
+			}
+			if got, want := hits, tt.hits; got != want {
+				t.Errorf("%s got %d want %d hits", tt.query, got, want)
+			}
+			if !cmp.Equal(terms, tt.terms) {
+				t.Errorf("%s got terms %v want %v", tt.query, terms, tt.terms)
+			}
+		})
+	}
+}
+
+func TestKeywordAnnotatorAndFilter(t *testing.T) {
+	k, err := NewKeywordSearch(`merger OR "share purchase"`, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotate := newKeywordAnnotator(*k)
+	nf := newFilterByKeywords("no keywords")
+
+	e := EmailWithSource{Headers: email.Headers{Subject: "share purchase"}, source: "test"}
+	e.bodies = []string{"the merger and the share purchase"}
+	annotate(&e)
+	if got, want := e.extra[keywordHitsColumn], "3"; got != want {
+		t.Errorf("got %s want %s hits", got, want)
+	}
+	if got, want := e.extra[keywordTermsColumn], `"share purchase"; merger`; got != want {
+		t.Errorf("got %s want %s terms", got, want)
+	}
+	if !accepted(nf, e) {
+		t.Error("expected email to pass the keyword filter")
+	}
+
+	e = EmailWithSource{Headers: email.Headers{Subject: "lunch"}, source: "test"}
+	annotate(&e)
+	if accepted(nf, e) {
+		t.Error("expected email to fail the keyword filter")
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	html := `<html><head><script>var x = "hidden";</script></head><body><p>Hello<br>world</p><p>again</p></body></html>`
+	if got, want := strings.Join(strings.Fields(htmlToText(html)), " "), "Hello world again"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
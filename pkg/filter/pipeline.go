@@ -0,0 +1,234 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Pipeline filters the emails in one or more Sources according to a
+// Config. NewPipeline sets up the Filters, together with the optional
+// report Columns set by the annotators the Config enables.
+type Pipeline struct {
+	Sources []Source
+	Config  Config
+	Filters *Filters
+	Columns []string
+	// AttachmentStore is set if attachments are to be exported
+	AttachmentStore *AttachmentStore
+	options         processOptions
+}
+
+// NewPipeline makes a Pipeline for the sources from config. It errors
+// if the attachment export directory, if configured, cannot be used.
+func NewPipeline(config Config, sources ...Source) (*Pipeline, error) {
+
+	p := Pipeline{
+		Sources: sources,
+		Config:  config,
+	}
+
+	// initialise the attachment store, checking that it does not already
+	// have an index
+	if config.AttachmentExportDir != "" {
+		store, err := NewAttachmentStore(config.AttachmentExportDir)
+		if err != nil {
+			return nil, err
+		}
+		p.AttachmentStore = store
+	}
+
+	// init Filters and annotators, together with the optional report
+	// columns set by annotators. Tag rules are run first so that emails
+	// kept for threading are also tagged.
+	filters := []Filter{}
+	for _, t := range config.TagRules {
+		filters = append(filters, newTagger(t))
+	}
+	filters = append(filters,
+		newFilterIP("ip invalid", config.ReceivedIPFragment),
+		newFilterByReportDate("outside daterange", config.ReportStart, config.ReportEnd, config.InclusiveDates),
+		newFilterByHoliday("on holiday", config.Holidays, config.InclusiveDates),
+		newFilterBySender("invalid sender", config.ValidSenderRegexp, config.SenderAllowList, config.SenderDenyList),
+	)
+	for _, r := range config.RecipientFilters {
+		filters = append(filters, newFilterByRecipient(r))
+	}
+	for _, h := range config.HeaderFilters {
+		filters = append(filters, newFilterByHeader(h))
+	}
+	for _, x := range config.ExpressionFilters {
+		filters = append(filters, newFilterByExpression(x))
+	}
+	annotators := []annotatorFunc{}
+	if k := config.KeywordSearch; k != nil {
+		annotators = append(annotators, newKeywordAnnotator(*k))
+		p.Columns = append(p.Columns, keywordHitsColumn, keywordTermsColumn)
+		if !k.Tag {
+			filters = append(filters, newFilterByKeywords("no keywords"))
+		}
+	}
+	if config.SenderIdentity != "" {
+		annotators = append(annotators, newSenderAnnotator(config.SenderIdentity))
+		p.Columns = append(p.Columns, senderHeaderColumn)
+	}
+	if config.ClassifyMessages {
+		annotators = append(annotators, newClassAnnotator())
+		p.Columns = append(p.Columns, messageClassColumn)
+		if len(config.MessageClasses) > 0 {
+			filters = append(filters, newFilterByClass("message class", config.MessageClasses))
+		}
+	}
+	if config.Authentication {
+		annotators = append(annotators, newAuthAnnotator(config.TrustedAuthServers))
+		p.Columns = append(p.Columns, authMethods...)
+		p.Columns = append(p.Columns, authDomainColumn)
+		for _, a := range config.AuthFilters {
+			filters = append(filters, newFilterByAuth(a))
+		}
+	}
+	if config.DKIMKeys != nil {
+		annotators = append(annotators, newDKIMAnnotator())
+		p.Columns = append(p.Columns, dkimVerificationColumn, dkimVerifiedDomainsColumn)
+		if len(config.DKIMResults) > 0 {
+			filters = append(filters, newFilterByDKIM("dkim verification", config.DKIMResults))
+		}
+	}
+	if config.Attachments {
+		annotators = append(annotators, newAttachmentAnnotator())
+		p.Columns = append(p.Columns, attachmentCountColumn, attachmentNamesColumn)
+		for _, a := range config.AttachmentFilters {
+			filters = append(filters, newFilterByAttachment(a))
+		}
+	}
+	if wh := config.WorkingHours; wh != nil {
+		if wh.Tag {
+			annotators = append(annotators, newWorkingHoursAnnotator(*wh))
+			p.Columns = append(p.Columns, workingHoursColumn)
+		} else {
+			filters = append(filters, newFilterByWorkingHours("out of hours", *wh))
+		}
+	}
+	// exec filters are run after the other filters, as they are slower
+	for _, x := range config.ExecFilters {
+		filters = append(filters, newFilterByExec(x))
+	}
+	if config.tagsEmails() {
+		p.Columns = append(p.Columns, TagsColumn)
+	}
+	if config.ThreadReport {
+		p.Columns = append(p.Columns, threadColumns...)
+		if config.IncludeWholeThreads {
+			p.Columns = append(p.Columns, threadExcludedColumn)
+		}
+	}
+	// the duplicate id filter should be last
+	filters = append(filters, newFilterByID("duplicate id"))
+	p.Filters = NewFilters(filters...)
+	p.Filters.AddAnnotators(annotators...)
+
+	// keep rejected emails for threading and parse bodies for keyword
+	// searches
+	p.options = processOptions{
+		keepRejected:     config.ThreadReport,
+		parseBodies:      config.KeywordSearch != nil,
+		parseAttachments: config.Attachments,
+		attachmentStore:  p.AttachmentStore,
+		dkimKeys:         config.DKIMKeys,
+	}
+	return &p, nil
+}
+
+// AddFilters adds filters to the pipeline, to be applied after the
+// configured filters but before the duplicate id filter, which is last
+// so that a rejected email does not mask a later duplicate.
+func (p *Pipeline) AddFilters(filters ...Filter) {
+	f := p.Filters.filters
+	last := len(f) - 1
+	p.Filters.filters = append(append(f[:last:last], filters...), f[last])
+}
+
+// Run processes the sources concurrently, putting emails on the email
+// chan and errors on the error chan, both of which are closed when
+// processing is complete. Processing stops on the first error or when
+// ctx is cancelled. Emails rejected by the filters are only put on the
+// email chan if the Config requests a thread report.
+func (p *Pipeline) Run(ctx context.Context) (<-chan EmailWithSource, <-chan error) {
+	return process(ctx, p.Sources, p.Filters, p.options)
+}
+
+// Collect runs the pipeline, collecting the emails, and reconstructs
+// their threads if the Config requests a thread report. Collect returns the first
+// processing error, if any, or the error of ctx if it was cancelled.
+func (p *Pipeline) Collect(ctx context.Context) (Emails, error) {
+	emailChan, errorChan := p.Run(ctx)
+	emails := NewEmails()
+	for e := range emailChan {
+		emails.Add(e)
+	}
+	for err := range errorChan {
+		if err != nil {
+			return nil, fmt.Errorf("processing error, %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// reconstruct threads, if required
+	if p.Config.ThreadReport {
+		emails = emails.Threads(p.Config.IncludeWholeThreads)
+	}
+	return emails, nil
+}
+
+// Stream runs the pipeline, writing each email to the sink as it is
+// processed rather than collecting the emails, so that memory use does
+// not grow with the size of the sources, and closes the sink. Emails are
+// written in the order they are processed; use a SortingSink to write
+// them sorted by date. Thread reports need all the emails and cannot be
+// streamed. Stream returns the first processing or writing error, if
+// any, or the error of ctx if it was cancelled.
+func (p *Pipeline) Stream(ctx context.Context, sink Sink) error {
+	if p.Config.ThreadReport {
+		return errors.New("thread reports cannot be streamed")
+	}
+	// stop processing on the first writing error
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	emailChan, errorChan := p.Run(ctx)
+	var writeErr error
+	for e := range emailChan {
+		if writeErr != nil {
+			continue // drain the chan
+		}
+		if writeErr = sink.Write(e); writeErr != nil {
+			stop()
+		}
+	}
+	var processErr error
+	for err := range errorChan {
+		if err != nil && processErr == nil {
+			processErr = fmt.Errorf("processing error, %w", err)
+		}
+	}
+	switch {
+	case writeErr != nil:
+		sink.Close()
+		return fmt.Errorf("writing error, %w", writeErr)
+	case processErr != nil:
+		sink.Close()
+		return processErr
+	case ctx.Err() != nil:
+		sink.Close()
+		return ctx.Err()
+	}
+	return sink.Close()
+}
+
+// Close releases any resources held by the filters, such as the helper
+// processes of exec filters
+func (p *Pipeline) Close() error {
+	return p.Filters.Close()
+}
@@ -0,0 +1,202 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// subjectFilter is a Filter implemented outside of the package filters
+type subjectFilter string
+
+func (s subjectFilter) Name() string { return "subject" }
+
+func (s subjectFilter) Evaluate(_ context.Context, e *EmailWithSource) (Decision, error) {
+	if strings.Contains(e.Subject, string(s)) {
+		return Accept, nil
+	}
+	return Reject, nil
+}
+
+// errorFilter is a Filter which always errors
+type errorFilter struct{}
+
+func (errorFilter) Name() string { return "error" }
+
+func (errorFilter) Evaluate(_ context.Context, _ *EmailWithSource) (Decision, error) {
+	return Reject, errors.New("helper unavailable")
+}
+
+// testConfig loads a configuration accepting emails of any date, ip
+// and sender, together with any further yaml options
+func testConfig(t *testing.T, options string) Config {
+	t.Helper()
+	config, err := LoadYaml([]byte(`
+reportStart: "2000-01-01"
+reportEnd:   "2030-12-31"
+receivedIPFragment: "."
+validSenderRegexpStr: "."
+` + options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// testPipeline makes a Pipeline for the sources from config
+func testPipeline(t *testing.T, config Config, sources ...Source) *Pipeline {
+	t.Helper()
+	p, err := NewPipeline(config, sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testCollect collects the emails of a Pipeline
+func testCollect(t *testing.T, p *Pipeline) Emails {
+	t.Helper()
+	emails, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return emails
+}
+
+func TestPipeline(t *testing.T) {
+	config := testConfig(t, `
+messageClassifier:
+  include: []
+`)
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		sources []Source
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "all emails",
+			sources: NewMboxFiles("testdata/golang.mbox", "testdata/gonuts.mbox"),
+			want:    3,
+		},
+		{
+			name:    "added filter",
+			filters: []Filter{subjectFilter("is released")},
+			sources: NewMboxFiles("testdata/golang.mbox", "testdata/gonuts.mbox"),
+			want:    1,
+		},
+		{
+			name:    "filter error",
+			filters: []Filter{errorFilter{}},
+			sources: NewMboxFiles("testdata/golang.mbox", "testdata/gonuts.mbox"),
+			wantErr: true,
+		},
+		{
+			name:    "missing source",
+			sources: NewMboxFiles("testdata/golang.mbox", "testdata/missing.mbox"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPipeline(t, config, tt.sources...)
+			p.AddFilters(tt.filters...)
+			emails, err := p.Collect(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(emails), tt.want; got != want {
+				t.Fatalf("got %d emails want %d", got, want)
+			}
+
+			var buf bytes.Buffer
+			if err := emails.Write(csv.NewWriter(&buf), 0, config.Location, p.Columns...); err != nil {
+				t.Fatal(err)
+			}
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(records), tt.want+1; got != want {
+				t.Errorf("got %d records want %d", got, want)
+			}
+			if got, want := records[0][len(records[0])-1], messageClassColumn; got != want {
+				t.Errorf("got last column %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestPipelineThreadColumns checks that the thread columns are added to
+// the pipeline columns once, however often the emails are collected
+func TestPipelineThreadColumns(t *testing.T) {
+	config := testConfig(t, "includeWholeThreads: true")
+	p := testPipeline(t, config, NewMboxFiles("testdata/golang.mbox")...)
+	want := append(append([]string{}, threadColumns...), threadExcludedColumn)
+	for range 2 {
+		testCollect(t, p)
+		if got := p.Columns; !slices.Equal(got, want) {
+			t.Errorf("got columns %v want %v", got, want)
+		}
+	}
+}
+
+// failingSink is a Sink which fails to write
+type failingSink struct{ closed bool }
+
+func (f *failingSink) Write(EmailWithSource) error { return errors.New("disk full") }
+
+func (f *failingSink) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPipelineStream(t *testing.T) {
+	config := testConfig(t, "")
+	sources := NewMboxFiles("testdata/golang.mbox", "testdata/gonuts.mbox")
+
+	p := testPipeline(t, config, sources...)
+	got := &recordingSink{}
+	if err := p.Stream(context.Background(), NewSortingSink(got, 1, t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+	if !got.closed {
+		t.Error("sink not closed")
+	}
+	if got, want := len(got.emails), 3; got != want {
+		t.Fatalf("got %d emails want %d", got, want)
+	}
+	for i := 1; i < len(got.emails); i++ {
+		if got.emails[i].Date.Before(got.emails[i-1].Date) {
+			t.Errorf("email %d not sorted by date", i)
+		}
+	}
+
+	p = testPipeline(t, config, sources...)
+	failing := &failingSink{}
+	if err := p.Stream(context.Background(), failing); err == nil {
+		t.Error("expected writing error")
+	}
+	if !failing.closed {
+		t.Error("failing sink not closed")
+	}
+
+	config.ThreadReport = true
+	p = testPipeline(t, config, sources...)
+	if err := p.Stream(context.Background(), &recordingSink{}); err == nil {
+		t.Error("expected thread report error")
+	}
+}
@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ProtonMail/go-mbox"
+	"github.com/rorycl/letters"
+	"github.com/rorycl/letters/email"
+	"github.com/rorycl/letters/parser"
+)
+
+// processOptions are options for processing mbox files
+type processOptions struct {
+	keepRejected bool // keep emails rejected by the filters, for example for threading
+	parseBodies  bool // parse the text and html bodies of emails for searching
+	// parse the inline and attached files of emails, recording each
+	// file with fileFunc
+	parseAttachments bool
+	// save the attachments of accepted emails to an optional store
+	attachmentStore *AttachmentStore
+	// verify the dkim signatures of emails with an optional key store
+	dkimKeys *DKIMKeyStore
+}
+
+// parserOptions returns the letters parser options for processing.
+// Attachments are read by fileFunc.
+func (o processOptions) parserOptions(fileFunc func(*email.File) error) []parser.Opt {
+	opts := []parser.Opt{
+		parser.WithCustomAddressFunc(lenientAddress),
+		parser.WithCustomAddressesFunc(lenientAddressList),
+	}
+	switch {
+	case o.parseAttachments:
+		return append(opts, parser.WithCustomFileFunc(fileFunc))
+	case o.parseBodies:
+		return append(opts, parser.WithoutAttachments())
+	}
+	return append(opts, parser.WithHeadersOnly())
+}
+
+// process processes email mbox sources, processing each source
+// concurrently, reading each email by email, putting emails on an email
+// chan and errors on an error chan. Processing should stop on first
+// error. Emails rejected by the filters are only put on the email chan
+// if opts.keepRejected is true. The bodies of emails are only parsed,
+// which is considerably slower than parsing headers alone, if
+// opts.parseBodies is true; they are discarded after filtering.
+// Attachments are only parsed if opts.parseAttachments is true, when
+// their size and sha256 sum are recorded without keeping their
+// contents, unless opts.attachmentStore is set, when the attachments of
+// emails accepted by the filters are stored. If opts.dkimKeys is set
+// each raw email is read in full to verify its DKIM signatures.
+// Processing also stops, without error, if ctx is cancelled.
+func process(ctx context.Context, sources []Source, filters *Filters, opts processOptions) (<-chan EmailWithSource, <-chan error) {
+
+	// stop signals the processing of all sources to stop
+	ctx, stop := context.WithCancel(ctx)
+	done := ctx.Done()
+	emailChan := make(chan EmailWithSource)
+	// each source reports at most one error
+	errorChan := make(chan error, len(sources))
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+
+	for _, source := range sources {
+		go func() {
+			defer wg.Done()
+			filer := source.Name()
+			f, err := source.Open()
+			if err != nil {
+				errorChan <- fmt.Errorf("file opening error, %w", err)
+				stop() // stop further processing
+				return
+			}
+			defer f.Close()
+
+			// record the bytes read from the source as it is read, and
+			// whether processing stopped on an error once it is
+			// processed
+			stoppedOnError := false
+			defer func() {
+				filters.recordSource(filer, stoppedOnError)
+			}()
+
+			mboxReader := mbox.NewReader(statsReader{r: f, name: filer, filters: filters})
+
+			for {
+				// stop processing early on done signal, to stop
+				// processing of concurrent mboxes after first error
+				select {
+				case <-done:
+					return
+				default:
+				}
+				msg, err := mboxReader.NextMessage()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					stoppedOnError = true
+					errorChan <- fmt.Errorf("mboxReader NextMessage error for %s, %w", filer, err)
+					stop() // stop further processing
+					return
+				}
+
+				var verification DKIMVerification
+				if opts.dkimKeys != nil {
+					raw, err := io.ReadAll(msg)
+					if err != nil {
+						stoppedOnError = true
+						errorChan <- fmt.Errorf("message reading error for %s, %w", filer, err)
+						stop() // stop further processing
+						return
+					}
+					verification = opts.dkimKeys.verifyDKIM(raw)
+					msg = bytes.NewReader(raw)
+				}
+
+				var attachments []Attachment
+				fileFunc := func(f *email.File) error {
+					a, err := newAttachment(f, opts.attachmentStore)
+					if err != nil {
+						return err
+					}
+					attachments = append(attachments, a)
+					return nil
+				}
+				p := letters.NewParser(opts.parserOptions(fileFunc)...)
+				message, err := p.Parse(msg)
+				if err != nil {
+					opts.attachmentStore.settle(attachments, false)
+					stoppedOnError = true
+					errorChan <- fmt.Errorf("letters parsing error for %s, %w", filer, err)
+					stop() // stop further processing
+					return
+				}
+
+				es := EmailWithSource{Headers: message.Headers, source: filer, attachments: attachments, dkim: verification}
+				if opts.parseBodies {
+					es.bodies = []string{message.Text, htmlToText(message.HTML)}
+				}
+
+				// continue if any filters return false, unless rejected
+				// emails are to be kept
+				ok, err := filters.Filter(ctx, &es)
+				es.bodies = nil
+				if err != nil {
+					opts.attachmentStore.settle(es.attachments, false)
+					stoppedOnError = true
+					errorChan <- fmt.Errorf("filtering error for %s, %w", filer, err)
+					stop() // stop further processing
+					return
+				}
+				if err := opts.attachmentStore.settle(es.attachments, ok); err != nil {
+					stoppedOnError = true
+					errorChan <- err
+					stop() // stop further processing
+					return
+				}
+				if !ok && !opts.keepRejected {
+					continue
+				}
+
+				// put email on email channel
+				select {
+				case emailChan <- es:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		stop()
+		close(emailChan)
+		close(errorChan)
+	}()
+	return emailChan, errorChan
+}
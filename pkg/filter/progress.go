@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ProgressFormats are the formats of progress reports: a line of text
+// rewritten in place, for a terminal, or json lines, one per report,
+// for programmes monitoring processing
+var ProgressFormats = []string{"text", "json"}
+
+// DefaultProgressInterval is the default interval between progress
+// reports
+const DefaultProgressInterval = time.Second
+
+// ProgressReport reports the progress of processing. Bytes is the total
+// size of the sources, or 0 if unknown, in which case the estimated
+// time remaining, ETASeconds, is also 0.
+type ProgressReport struct {
+	ElapsedSeconds    float64 `json:"elapsedSeconds"`
+	Files             int     `json:"files"`
+	FilesDone         int     `json:"filesDone"`
+	Messages          int     `json:"messages"`
+	Accepted          int     `json:"accepted"`
+	BytesRead         int64   `json:"bytesRead"`
+	Bytes             int64   `json:"bytes"`
+	MessagesPerSecond float64 `json:"messagesPerSecond"`
+	ETASeconds        float64 `json:"etaSeconds"`
+	Done              bool    `json:"done"`
+}
+
+// Progress periodically writes reports of the progress of processing
+// sources, from the stats recorded by the Filters as each source is read
+// and each email filtered, until it is stopped.
+type Progress struct {
+	w        io.Writer
+	format   string
+	interval time.Duration
+	filters  *Filters
+	files    int
+	bytes    int64 // total size of the sources, 0 if unknown
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewProgress makes a Progress writing reports to w in one of
+// ProgressFormats every interval, or DefaultProgressInterval if
+// interval is 0, for processing sources with filters. The total size of
+// the sources is only known if every source implements Sizer.
+func NewProgress(w io.Writer, format string, interval time.Duration, filters *Filters, sources ...Source) (*Progress, error) {
+	if !slices.Contains(ProgressFormats, format) {
+		return nil, fmt.Errorf("progress format %q not one of %v", format, ProgressFormats)
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	p := Progress{
+		w:        w,
+		format:   format,
+		interval: interval,
+		filters:  filters,
+		files:    len(sources),
+	}
+	for _, s := range sources {
+		sizer, ok := s.(Sizer)
+		if !ok {
+			p.bytes = 0
+			break
+		}
+		n, err := sizer.Size()
+		if err != nil {
+			p.bytes = 0
+			break
+		}
+		p.bytes += n
+	}
+	return &p, nil
+}
+
+// Report returns a report of the progress of processing so far
+func (p *Progress) Report() ProgressReport {
+	stats := p.filters.ProcessingStats()
+	r := ProgressReport{
+		ElapsedSeconds: time.Since(stats.Start).Seconds(),
+		Files:          p.files,
+		FilesDone:      stats.SourcesDone,
+		Messages:       stats.Total.Emails,
+		Accepted:       stats.Total.Accepted,
+		BytesRead:      stats.Total.BytesRead,
+		Bytes:          p.bytes,
+	}
+	if r.ElapsedSeconds > 0 {
+		r.MessagesPerSecond = float64(r.Messages) / r.ElapsedSeconds
+		if r.BytesRead > 0 && r.Bytes > r.BytesRead {
+			bytesPerSecond := float64(r.BytesRead) / r.ElapsedSeconds
+			r.ETASeconds = float64(r.Bytes-r.BytesRead) / bytesPerSecond
+		}
+	}
+	return r
+}
+
+// Start starts writing reports, until Stop is called
+func (p *Progress) Start() {
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.write(p.Report())
+			}
+		}
+	}()
+}
+
+// Stop stops writing reports, and writes a final report marked done
+func (p *Progress) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+	r := p.Report()
+	r.Done = true
+	r.ETASeconds = 0
+	p.write(r)
+}
+
+// write writes a report; text reports rewrite the current line, which
+// is ended by the final report
+func (p *Progress) write(r ProgressReport) {
+	if p.format == "json" {
+		b, _ := json.Marshal(r)
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+	end := ""
+	if r.Done {
+		end = "\n"
+	}
+	fmt.Fprintf(p.w, "\r%s\x1b[K%s", r, end)
+}
+
+// String describes the progress on one line
+func (r ProgressReport) String() string {
+	s := fmt.Sprintf("files %d/%d  messages %d  ", r.FilesDone, r.Files, r.Messages)
+	if r.Bytes > 0 {
+		s += fmt.Sprintf("%s/%s (%.0f%%)", formatBytes(r.BytesRead), formatBytes(r.Bytes), 100*float64(r.BytesRead)/float64(r.Bytes))
+	} else {
+		s += formatBytes(r.BytesRead)
+	}
+	s += fmt.Sprintf("  %.0f msg/s", r.MessagesPerSecond)
+	if r.ETASeconds > 0 {
+		s += fmt.Sprintf("  eta %s", time.Duration(r.ETASeconds*float64(time.Second)).Round(time.Second))
+	}
+	return s
+}
+
+// formatBytes formats a number of bytes in binary units, such as
+// "12.3MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,122 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsizedSource is a Source which does not know its size
+type unsizedSource struct{ path string }
+
+func (u unsizedSource) Name() string                 { return u.path }
+func (u unsizedSource) Open() (io.ReadCloser, error) { return os.Open(u.path) }
+
+func TestProgress(t *testing.T) {
+	const copies = 16
+	paths := []string{}
+	var size int64
+	for range copies {
+		paths = append(paths, "testdata/golang.mbox", "testdata/gonuts.mbox")
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	p := testPipeline(t, testConfig(t, ""), NewMboxFiles(paths...)...)
+
+	var buf bytes.Buffer
+	progress, err := NewProgress(&buf, "json", time.Millisecond, p.Filters, p.Sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.Start()
+	testCollect(t, p)
+	progress.Stop()
+
+	var reports []ProgressReport
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var r ProgressReport
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid json line %q, %s", scanner.Text(), err)
+		}
+		reports = append(reports, r)
+	}
+	if len(reports) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Messages < reports[i-1].Messages || reports[i].BytesRead < reports[i-1].BytesRead {
+			t.Errorf("report %d went backwards: %+v after %+v", i, reports[i], reports[i-1])
+		}
+	}
+	last := reports[len(reports)-1]
+	want := ProgressReport{
+		Files:     2 * copies,
+		FilesDone: 2 * copies,
+		Messages:  3 * copies,
+		Accepted:  3,
+		BytesRead: size,
+		Bytes:     size,
+		Done:      true,
+	}
+	last.ElapsedSeconds, last.MessagesPerSecond = 0, 0
+	if last != want {
+		t.Errorf("got final report %+v want %+v", last, want)
+	}
+}
+
+func TestProgressText(t *testing.T) {
+	sources := []Source{unsizedSource{"testdata/golang.mbox"}, unsizedSource{"testdata/gonuts.mbox"}}
+	p := testPipeline(t, testConfig(t, ""), sources...)
+	var buf bytes.Buffer
+	progress, err := NewProgress(&buf, "text", 0, p.Filters, p.Sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.Start()
+	testCollect(t, p)
+	progress.Stop()
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "\r") || !strings.HasSuffix(out, "\n") {
+		t.Errorf("unexpected text progress %q", out)
+	}
+	// the size of unsized sources is unknown, so there is no eta
+	if !strings.Contains(out, "files 2/2  messages 3  36.9KiB  ") || strings.Contains(out, "eta") {
+		t.Errorf("unexpected text progress %q", out)
+	}
+
+	if _, err := NewProgress(&buf, "xml", 0, p.Filters); err == nil {
+		t.Error("expected progress format error")
+	}
+}
+
+func TestProgressReportString(t *testing.T) {
+	r := ProgressReport{
+		Files:             4,
+		FilesDone:         1,
+		Messages:          1200,
+		BytesRead:         3 << 20,
+		Bytes:             12 << 20,
+		MessagesPerSecond: 400,
+		ETASeconds:        9.4,
+	}
+	if got, want := r.String(), "files 1/4  messages 1200  3.0MiB/12.0MiB (25%)  400 msg/s  eta 9s"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	for n, want := range map[int64]string{0: "0B", 1023: "1023B", 1024: "1.0KiB", 1536: "1.5KiB", 5 << 30: "5.0GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("%d got %s want %s", n, got, want)
+		}
+	}
+}
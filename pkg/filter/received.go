@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReceivedHop describes a Received header (RFC 5321 4.4) recording the
+// transfer of an email from one server to the next. Fields not present
+// in the header are empty.
+type ReceivedHop struct {
+	From string    `expr:"from"` // the sending host
+	IP   string    `expr:"ip"`   // the ip address of the sending host, if recorded
+	By   string    `expr:"by"`   // the receiving host
+	With string    `expr:"with"` // the protocol, such as esmtps
+	ID   string    `expr:"id"`
+	For  string    `expr:"for"` // the recipient address
+	Date time.Time `expr:"date"`
+}
+
+// receivedClauseRegexp matches the clauses of a Received header, after
+// comments are removed
+var receivedClauseRegexp = regexp.MustCompile(`(?i)(?:^|\s)(from|by|via|with|id|for)\s+(\S+)`)
+
+// receivedIPRegexp matches an ip address literal, which is normally in
+// a comment following the sending host
+var receivedIPRegexp = regexp.MustCompile(`\[(?:IPv6:)?([0-9a-fA-F.:]+)\]`)
+
+// parseReceived parses a Received header value such as
+//
+//	from mail.example.com (mail.example.com [192.0.2.1]) by mx.example.net
+//	with esmtps id 1qTOa4-000BKS-0H for <bob@example.net>; Tue, 08 Aug
+//	2023 15:25:10 +0000
+func parseReceived(v string) ReceivedHop {
+	var hop ReceivedHop
+	clauses := v
+	if i := strings.LastIndex(v, ";"); i >= 0 {
+		clauses = v[:i]
+		if d, err := mail.ParseDate(strings.TrimSpace(v[i+1:])); err == nil {
+			hop.Date = d
+		}
+	}
+	// the ip address is recorded before the receiving host
+	from := clauses
+	if i := strings.Index(strings.ToLower(clauses), " by "); i >= 0 {
+		from = clauses[:i]
+	}
+	if m := receivedIPRegexp.FindStringSubmatch(from); m != nil {
+		hop.IP = m[1]
+	}
+	for _, m := range receivedClauseRegexp.FindAllStringSubmatch(stripComments(clauses), -1) {
+		value := strings.Trim(m[2], "<>")
+		switch strings.ToLower(m[1]) {
+		case "from":
+			hop.From = value
+		case "by":
+			hop.By = value
+		case "with":
+			hop.With = value
+		case "id":
+			hop.ID = value
+		case "for":
+			hop.For = value
+		}
+	}
+	return hop
+}
+
+// ReceivedHops returns the parsed Received headers of the email, in the
+// order of the headers, so that the last server to receive the email is
+// first.
+func (e EmailWithSource) ReceivedHops() []ReceivedHop {
+	hops := []ReceivedHop{}
+	for _, v := range e.Received {
+		hops = append(hops, parseReceived(v))
+	}
+	return hops
+}
@@ -0,0 +1,55 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseReceived(t *testing.T) {
+	tests := []struct {
+		received string
+		want     ReceivedHop
+	}{
+		{
+			received: "from mail-yb1-f185.google.com ([209.85.219.185]) by campbell-lange.net with esmtps  (TLS1.3) tls TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Exim 4.96) (envelope-from <golang-nuts+bncBAABBUN4ZGTAMGQEJC7YT2A@googlegroups.com>) id 1qTOa4-000BKS-0H for example@test.com; Tue, 08 Aug 2023 15:25:10 +0000",
+			want: ReceivedHop{
+				From: "mail-yb1-f185.google.com",
+				IP:   "209.85.219.185",
+				By:   "campbell-lange.net",
+				With: "esmtps",
+				ID:   "1qTOa4-000BKS-0H",
+				For:  "example@test.com",
+				Date: time.Date(2023, 8, 8, 15, 25, 10, 0, time.UTC),
+			},
+		},
+		{
+			received: "from mail-pf1-x435.google.com (mail-pf1-x435.google.com. [2607:f8b0:4864:20::435]) by gmr-mx.google.com with ESMTPS id dw22 for <golang-nuts@googlegroups.com> (version=TLS1_3); Mon, 25 Sep 2023 21:20:35 -0700 (PDT)",
+			want: ReceivedHop{
+				From: "mail-pf1-x435.google.com",
+				IP:   "2607:f8b0:4864:20::435",
+				By:   "gmr-mx.google.com",
+				With: "ESMTPS",
+				ID:   "dw22",
+				For:  "golang-nuts@googlegroups.com",
+				Date: time.Date(2023, 9, 26, 4, 20, 35, 0, time.UTC),
+			},
+		},
+		{
+			received: "by 2002:a05:6808:2209:b0:3a4:244d:fe3e with SMTP id 5614622812f47-3a7b39bbfdbmsb6e",
+			want: ReceivedHop{
+				By:   "2002:a05:6808:2209:b0:3a4:244d:fe3e",
+				With: "SMTP",
+				ID:   "5614622812f47-3a7b39bbfdbmsb6e",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := parseReceived(tt.received)
+		if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })); diff != "" {
+			t.Errorf("parseReceived mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
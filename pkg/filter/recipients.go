@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// recipientHeaders are the headers which may be used for recipient
+// filtering. Delivered-To and X-Original-To are envelope recipients
+// added on delivery and are read from the extra headers.
+var recipientHeaders = []string{"to", "cc", "bcc", "delivered-to", "x-original-to"}
+
+// RecipientFilter describes a filter over the recipients of an email.
+//
+// A recipient in one of Headers is matched if it matches any of the
+// Include regular expressions. With MatchAll false an email passes if
+// any recipient is matched; with MatchAll true every recipient must be
+// matched. An email is rejected if any recipient matches any of the
+// Exclude regular expressions. A filter with only Exclude regular
+// expressions passes all emails not excluded.
+type RecipientFilter struct {
+	Name     string
+	Headers  []string // lower case header names from recipientHeaders
+	MatchAll bool
+	Include  []*regexp.Regexp
+	Exclude  []*regexp.Regexp
+}
+
+func (r RecipientFilter) String() string {
+	return fmt.Sprintf("%s (%s) all %t include %v exclude %v", r.Name, strings.Join(r.Headers, ","), r.MatchAll, r.Include, r.Exclude)
+}
+
+// extraHeaderAddresses parses the addresses in an extra header, such as
+// Delivered-To, keeping the raw value of any that cannot be parsed.
+func extraHeaderAddresses(e EmailWithSource, header string) []string {
+	addresses := []string{}
+	for _, v := range e.ExtraHeaders[textproto.CanonicalMIMEHeaderKey(header)] {
+		list, err := mail.ParseAddressList(v)
+		if err != nil {
+			addresses = append(addresses, strings.Trim(strings.TrimSpace(v), "<>"))
+			continue
+		}
+		for _, a := range list {
+			addresses = append(addresses, a.Address)
+		}
+	}
+	return addresses
+}
+
+// addressStrings returns the addresses in list
+func addressStrings(list []*mail.Address) []string {
+	addresses := []string{}
+	for _, a := range list {
+		if a != nil {
+			addresses = append(addresses, a.Address)
+		}
+	}
+	return addresses
+}
+
+// recipients returns the addresses of an email in the given headers
+func recipients(e EmailWithSource, headers []string) []string {
+	addresses := []string{}
+	for _, h := range headers {
+		switch h {
+		case "to":
+			addresses = append(addresses, addressStrings(e.To)...)
+		case "cc":
+			addresses = append(addresses, addressStrings(e.Cc)...)
+		case "bcc":
+			addresses = append(addresses, addressStrings(e.Bcc)...)
+		default:
+			addresses = append(addresses, extraHeaderAddresses(e, h)...)
+		}
+	}
+	return addresses
+}
+
+// matchesAny reports if s matches any of the regular expressions
+func matchesAny(s string, regexps []*regexp.Regexp) bool {
+	for _, r := range regexps {
+		if r.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// newFilterByRecipient filters emails by their recipients as described
+// by a RecipientFilter
+func newFilterByRecipient(r RecipientFilter) Filter {
+	return newFilter(r.Name, func(e EmailWithSource) bool {
+		addresses := recipients(e, r.Headers)
+		matched := 0
+		for _, a := range addresses {
+			if matchesAny(a, r.Exclude) {
+				return false
+			}
+			if matchesAny(a, r.Include) {
+				matched++
+			}
+		}
+		if len(r.Include) == 0 {
+			return true
+		}
+		if r.MatchAll {
+			return len(addresses) > 0 && matched == len(addresses)
+		}
+		return matched > 0
+	})
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"testing"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestRecipients(t *testing.T) {
+	e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+	e.To = []*mail.Address{{Address: "a@client.com"}}
+	e.Cc = []*mail.Address{{Address: "b@smythersbrown.net"}, {Address: "c@client.com"}}
+	e.ExtraHeaders = map[string][]string{
+		"Delivered-To":  {"archive@smythersbrown.net"},
+		"X-Original-To": {"<d@client.com>, e@other.org"},
+	}
+	got := fmt.Sprint(recipients(e, recipientHeaders))
+	want := "[a@client.com b@smythersbrown.net c@client.com archive@smythersbrown.net d@client.com e@other.org]"
+	if got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestRecipientFilter(t *testing.T) {
+
+	client := regexp.MustCompile(`(?i)@client\.com$`)
+	lawyer := regexp.MustCompile(`(?i)^counsel@`)
+
+	anyClient := newFilterByRecipient(RecipientFilter{
+		Name: "any", Headers: recipientHeaders, Include: []*regexp.Regexp{client}})
+	allClient := newFilterByRecipient(RecipientFilter{
+		Name: "all", Headers: []string{"to", "cc"}, MatchAll: true, Include: []*regexp.Regexp{client}})
+	ccClientNoLawyer := newFilterByRecipient(RecipientFilter{
+		Name: "cc", Headers: []string{"cc"}, Include: []*regexp.Regexp{client}, Exclude: []*regexp.Regexp{lawyer}})
+	noLawyer := newFilterByRecipient(RecipientFilter{
+		Name: "exclude", Headers: recipientHeaders, Exclude: []*regexp.Regexp{lawyer}})
+
+	tests := []struct {
+		to, cc                     []string
+		any, all, ccClient, noLawy bool
+	}{
+		{[]string{"a@client.com"}, nil, true, true, false, true},
+		{[]string{"a@client.com"}, []string{"b@CLIENT.com"}, true, true, true, true},
+		{[]string{"x@other.org"}, []string{"b@client.com"}, true, false, true, true},
+		{[]string{"x@other.org"}, []string{"counsel@client.com"}, true, false, false, false},
+		{[]string{"x@other.org"}, nil, false, false, false, true},
+		{nil, nil, false, false, false, true},
+	}
+
+	addresses := func(s []string) []*mail.Address {
+		a := []*mail.Address{}
+		for _, addr := range s {
+			a = append(a, &mail.Address{Address: addr})
+		}
+		return a
+	}
+
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.To = addresses(tt.to)
+		e.Cc = addresses(tt.cc)
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			for _, f := range []struct {
+				nf   Filter
+				want bool
+			}{
+				{anyClient, tt.any},
+				{allClient, tt.all},
+				{ccClientNoLawyer, tt.ccClient},
+				{noLawyer, tt.noLawy},
+			} {
+				if got := accepted(f.nf, e); got != f.want {
+					t.Errorf("%s filter to %v cc %v got %t want %t", f.nf.Name(), tt.to, tt.cc, got, f.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// senderIdentities are the headers from which the sender of an email
+// may be determined, in their default order of precedence. "envelope"
+// refers to the envelope-from recorded in the Received headers.
+var senderIdentities = []string{"from", "sender", "return-path", "envelope"}
+
+// defaultSenderIdentity is the default identity used as the sender
+const defaultSenderIdentity = "from"
+
+// senderHeaderColumn is the optional report column recording which
+// header provided the sender of an email
+const senderHeaderColumn = "sender header"
+
+// envelopeFromRegexp extracts the envelope-from address recorded in a
+// Received header
+var envelopeFromRegexp = regexp.MustCompile(`(?i)envelope-from\s*<([^>]*)>`)
+
+// addressRegexp finds address-like strings in headers which cannot
+// otherwise be parsed
+var addressRegexp = regexp.MustCompile(`[^\s<>"(),;:@]+@[^\s<>"(),;:@]+`)
+
+// lenientAddressList parses a list of addresses, such as a From header,
+// falling back to extracting address-like strings if the list cannot be
+// parsed, so that malformed headers do not stop processing. Group
+// syntax such as "undisclosed-recipients:;" results in the addresses of
+// the group members, if any.
+func lenientAddressList(list string) ([]*mail.Address, error) {
+	addresses, err := mail.ParseAddressList(list)
+	if err == nil {
+		return addresses, nil
+	}
+	addresses = []*mail.Address{}
+	for _, a := range addressRegexp.FindAllString(list, -1) {
+		addresses = append(addresses, &mail.Address{Address: a})
+	}
+	return addresses, nil
+}
+
+// lenientAddress parses a single address using lenientAddressList,
+// returning nil if no address is found
+func lenientAddress(s string) (*mail.Address, error) {
+	addresses, _ := lenientAddressList(s)
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	return addresses[0], nil
+}
+
+// firstAddress returns the first non-empty address in a list
+func firstAddress(addresses []*mail.Address) string {
+	for _, a := range addresses {
+		if a != nil && a.Address != "" {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+// identityAddress returns the address of the email for the given
+// sender identity, or an empty string if there is none.
+func (e EmailWithSource) identityAddress(identity string) string {
+	switch identity {
+	case "from":
+		return firstAddress(e.From)
+	case "sender":
+		if e.Sender != nil {
+			return e.Sender.Address
+		}
+	case "return-path":
+		for _, rp := range e.ExtraHeaders["Return-Path"] {
+			// "<>" is the null return path used by bounces
+			if a := strings.Trim(strings.TrimSpace(rp), "<>"); a != "" {
+				return a
+			}
+		}
+	case "envelope":
+		// use the earliest Received header, closest to the origin
+		for i := len(e.Received) - 1; i >= 0; i-- {
+			if m := envelopeFromRegexp.FindStringSubmatch(e.Received[i]); m != nil && m[1] != "" {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// resolveSender returns the sender address of an email and the header
+// from which it was taken, trying the preferred identity first and then
+// falling back to the other senderIdentities in order. A From header
+// with several addresses is resolved to the Sender header (as required
+// by RFC 5322 section 3.6.2) if present, otherwise the first From
+// address. An empty address is returned if no sender can be found.
+func (e EmailWithSource) resolveSender(identity string) (string, string) {
+	for _, id := range append([]string{identity}, senderIdentities...) {
+		if id == "from" && len(e.From) > 1 && e.Sender != nil && e.Sender.Address != "" {
+			return e.Sender.Address, "sender"
+		}
+		if a := e.identityAddress(id); a != "" {
+			return a, id
+		}
+	}
+	return "", ""
+}
+
+// senderAddress returns the sender of an email, as set by a sender
+// annotator or otherwise resolved using the default identity.
+func (e EmailWithSource) senderAddress() string {
+	if e.sender != "" {
+		return e.sender
+	}
+	sender, _ := e.resolveSender(defaultSenderIdentity)
+	return sender
+}
+
+// validSenderIdentity checks that an identity is one of
+// senderIdentities
+func validSenderIdentity(identity string) error {
+	for _, s := range senderIdentities {
+		if s == identity {
+			return nil
+		}
+	}
+	return fmt.Errorf("sender identity %q not one of %v", identity, senderIdentities)
+}
+
+// newSenderAnnotator resolves the sender of each email using the
+// preferred identity, recording the header used in the
+// senderHeaderColumn.
+func newSenderAnnotator(identity string) annotatorFunc {
+	return func(e *EmailWithSource) {
+		var header string
+		e.sender, header = e.resolveSender(identity)
+		e.setExtra(senderHeaderColumn, header)
+	}
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"fmt"
+	"net/mail"
+	"testing"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestLenientAddressList(t *testing.T) {
+	tests := []struct {
+		list string
+		want string
+	}{
+		{"Bob <bob@example.com>", "[bob@example.com]"},
+		{"bob@example.com, alice@example.com", "[bob@example.com alice@example.com]"},
+		{"undisclosed-recipients:;", "[]"},
+		{"Team: bob@example.com, alice@example.com;", "[bob@example.com alice@example.com]"},
+		{"Bob Smith, Acme <bob@example.com> (broken", "[bob@example.com]"},
+		{"no address here", "[]"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			addresses, err := lenientAddressList(tt.list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, a := range addresses {
+				got = append(got, a.Address)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSender(t *testing.T) {
+
+	received := []string{
+		"from mx.example.com by smythersbrown.net (envelope-from <relay@example.com>) id 2",
+		"from laptop by mx.example.com (envelope-from <origin@example.com>) id 1",
+	}
+	address := func(s string) *mail.Address { return &mail.Address{Address: s} }
+
+	tests := []struct {
+		from       []*mail.Address
+		sender     *mail.Address
+		returnPath string
+		received   []string
+		identity   string
+		want       string
+		header     string
+	}{
+		{[]*mail.Address{address("a@example.com")}, nil, "", nil, "from", "a@example.com", "from"},
+		// multiple From addresses resolve to the Sender
+		{[]*mail.Address{address("a@example.com"), address("b@example.com")}, address("s@example.com"), "", nil, "from", "s@example.com", "sender"},
+		{[]*mail.Address{address("a@example.com"), address("b@example.com")}, nil, "", nil, "from", "a@example.com", "from"},
+		// missing From falls back
+		{nil, address("s@example.com"), "<rp@example.com>", nil, "from", "s@example.com", "sender"},
+		{nil, nil, "<rp@example.com>", received, "from", "rp@example.com", "return-path"},
+		{nil, nil, "<>", received, "from", "origin@example.com", "envelope"},
+		{nil, nil, "", nil, "from", "", ""},
+		// preferred identities
+		{[]*mail.Address{address("a@example.com")}, nil, "<rp@example.com>", received, "return-path", "rp@example.com", "return-path"},
+		{[]*mail.Address{address("a@example.com")}, nil, "", received, "envelope", "origin@example.com", "envelope"},
+		{[]*mail.Address{address("a@example.com")}, nil, "", nil, "sender", "a@example.com", "from"},
+	}
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.From = tt.from
+		e.Sender = tt.sender
+		e.Received = tt.received
+		e.ExtraHeaders = map[string][]string{}
+		if tt.returnPath != "" {
+			e.ExtraHeaders["Return-Path"] = []string{tt.returnPath}
+		}
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got, header := e.resolveSender(tt.identity)
+			if got != tt.want || header != tt.header {
+				t.Errorf("got %q (%s) want %q (%s)", got, header, tt.want, tt.header)
+			}
+			newSenderAnnotator(tt.identity)(&e)
+			if e.senderAddress() != tt.want || e.extra[senderHeaderColumn] != tt.header {
+				t.Errorf("annotated got %q (%s) want %q (%s)", e.senderAddress(), e.extra[senderHeaderColumn], tt.want, tt.header)
+			}
+		})
+	}
+}
+
+func TestSenderFilterMissingFrom(t *testing.T) {
+	nf := newFilterBySender("sender filter", nil, nil, nil)
+	e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+	if got, want := accepted(nf, e), false; got != want {
+		t.Errorf("got %t want %t", got, want)
+	}
+}
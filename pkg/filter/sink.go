@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sink is implemented by writers of emails, such as reports. Close
+// should be called after the last email is written.
+type Sink interface {
+	Write(e EmailWithSource) error
+	Close() error
+}
+
+// multiSink is a Sink writing to several Sinks
+type multiSink []Sink
+
+// NewMultiSink makes a Sink writing each email to each of sinks, in
+// order, for example to write a report and an attachment inventory in
+// one pass
+func NewMultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+// Write writes an email to each sink, stopping on first error
+func (m multiSink) Write(e EmailWithSource) error {
+	for _, s := range m {
+		if err := s.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes each sink
+func (m multiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// CSVSink is a Sink writing emails as csv records to a csv.Writer,
+// after a header of the standard csvHeader columns and any optional
+// columns.
+type CSVSink struct {
+	writer     *csv.Writer
+	subjectLen int
+	loc        *time.Location
+	columns    []string
+	started    bool // the header has been written
+}
+
+// NewCSVSink makes a CSVSink using a maximum subject length of
+// subjectLen (use 0 to write the whole subject) and showing dates in the
+// timezone loc. The values of any optional columns are written after the
+// standard csvHeader columns.
+func NewCSVSink(writer *csv.Writer, subjectLen int, loc *time.Location, columns ...string) *CSVSink {
+	return &CSVSink{
+		writer:     writer,
+		subjectLen: subjectLen,
+		loc:        loc,
+		columns:    columns,
+	}
+}
+
+// writeHeader writes the csv header, once
+func (c *CSVSink) writeHeader() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if err := c.writer.Write(append(append([]string{}, csvHeader...), c.columns...)); err != nil {
+		return fmt.Errorf("csv header writing error, %w", err)
+	}
+	return nil
+}
+
+// Write writes an email as a csv record
+func (c *CSVSink) Write(e EmailWithSource) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	if err := c.writer.Write(e.forCSV(c.subjectLen, c.loc, c.columns...)); err != nil {
+		return fmt.Errorf("csv writing error, %w", err)
+	}
+	return nil
+}
+
+// Close writes the header if no emails were written and flushes the
+// csv.Writer. It does not close the underlying writer.
+func (c *CSVSink) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
@@ -0,0 +1,271 @@
+package filter
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rorycl/letters/email"
+)
+
+// DefaultSortLimit is the default number of emails a SortingSink holds
+// in memory before spilling them to a temporary file
+const DefaultSortLimit = 100000
+
+// SortingSink is a Sink sorting emails by date before writing them to
+// another Sink, using an external merge sort so that at most Limit
+// emails are held in memory. When Limit emails have been written they
+// are sorted and spilled to a temporary file in Dir; on Close the
+// spilled runs and any emails still in memory are merged and written to
+// the next Sink, which is then closed. Emails with the same date are
+// written in the order they were written to the SortingSink.
+type SortingSink struct {
+	Limit  int
+	Dir    string // directory for temporary files, os.TempDir() if empty
+	next   Sink
+	emails Emails
+	spills []string // paths of the spilled runs, in order
+}
+
+// NewSortingSink makes a SortingSink writing to next, holding at most
+// limit emails in memory, and spilling emails to temporary files in dir.
+func NewSortingSink(next Sink, limit int, dir string) *SortingSink {
+	if limit < 1 {
+		limit = DefaultSortLimit
+	}
+	return &SortingSink{Limit: limit, Dir: dir, next: next}
+}
+
+// spilledEmail is the form of an email in a spill file. The content
+// information of the email headers is not kept, as it is not reported.
+type spilledEmail struct {
+	Headers     email.Headers
+	Source      string
+	Sender      string
+	Rejected    string
+	Extra       map[string]string
+	Attachments []Attachment
+	DKIM        DKIMVerification
+	Tags        []string
+}
+
+// newSpilledEmail makes the spilledEmail for an email
+func newSpilledEmail(e EmailWithSource) spilledEmail {
+	h := e.Headers
+	h.ContentInfo = nil
+	return spilledEmail{
+		Headers:     h,
+		Source:      e.source,
+		Sender:      e.sender,
+		Rejected:    e.rejected,
+		Extra:       e.extra,
+		Attachments: e.attachments,
+		DKIM:        e.dkim,
+		Tags:        e.tags,
+	}
+}
+
+// email returns the email of a spilledEmail
+func (s spilledEmail) email() EmailWithSource {
+	return EmailWithSource{
+		Headers:     s.Headers,
+		source:      s.Source,
+		sender:      s.Sender,
+		rejected:    s.Rejected,
+		extra:       s.Extra,
+		attachments: s.Attachments,
+		dkim:        s.DKIM,
+		tags:        s.Tags,
+	}
+}
+
+// Write adds an email, spilling the emails in memory if the limit is
+// reached
+func (s *SortingSink) Write(e EmailWithSource) error {
+	s.emails = append(s.emails, e)
+	if len(s.emails) < s.Limit {
+		return nil
+	}
+	return s.spill()
+}
+
+// spill sorts the emails in memory and writes them to a temporary file
+func (s *SortingSink) spill() error {
+	s.emails.SortByDate()
+	f, err := os.CreateTemp(s.Dir, "mboxfilterer-sort-*")
+	if err != nil {
+		return fmt.Errorf("sort spill error, %w", err)
+	}
+	s.spills = append(s.spills, f.Name())
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, e := range s.emails {
+		if err := enc.Encode(newSpilledEmail(e)); err != nil {
+			f.Close()
+			return fmt.Errorf("sort spill encoding error, %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("sort spill error, %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sort spill error, %w", err)
+	}
+	s.emails = s.emails[:0]
+	return nil
+}
+
+// sortRun is a sorted run of emails, either spilled or in memory
+type sortRun struct {
+	order  int // the order of the run, to keep emails with the same date in order
+	head   EmailWithSource
+	next   func() (EmailWithSource, error)
+	closer io.Closer
+}
+
+// sortRuns is a min heap of sortRuns ordered by their head email
+type sortRuns []*sortRun
+
+func (r sortRuns) Len() int { return len(r) }
+func (r sortRuns) Less(i, j int) bool {
+	if r[i].head.Date.Equal(r[j].head.Date) {
+		return r[i].order < r[j].order
+	}
+	return r[i].head.Date.Before(r[j].head.Date)
+}
+func (r sortRuns) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r *sortRuns) Push(x any)   { *r = append(*r, x.(*sortRun)) }
+func (r *sortRuns) Pop() any {
+	old := *r
+	n := len(old)
+	x := old[n-1]
+	*r = old[:n-1]
+	return x
+}
+
+// close closes the file of a spilled run, if it is still open
+func (r *sortRun) close() {
+	if r.closer != nil {
+		r.closer.Close()
+		r.closer = nil
+	}
+}
+
+// openSpill opens a spilled run
+func openSpill(path string, order int) (*sortRun, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("sort spill error, %w", err)
+	}
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	return &sortRun{
+		order: order,
+		next: func() (EmailWithSource, error) {
+			var s spilledEmail
+			if err := dec.Decode(&s); err != nil {
+				return EmailWithSource{}, err
+			}
+			return s.email(), nil
+		},
+		closer: f,
+	}, nil
+}
+
+// memoryRun makes a run of the sorted emails in memory
+func memoryRun(emails Emails, order int) *sortRun {
+	i := 0
+	return &sortRun{
+		order: order,
+		next: func() (EmailWithSource, error) {
+			if i == len(emails) {
+				return EmailWithSource{}, io.EOF
+			}
+			i++
+			return emails[i-1], nil
+		},
+	}
+}
+
+// Close merges the spilled runs and the emails in memory, writing them
+// to the next Sink, and removes the spill files. The next Sink is
+// closed even if merging fails.
+func (s *SortingSink) Close() error {
+	defer func() {
+		for _, p := range s.spills {
+			os.Remove(p)
+		}
+		s.spills = nil
+	}()
+	if err := s.merge(); err != nil {
+		return errors.Join(err, s.next.Close())
+	}
+	s.emails = nil
+	return s.next.Close()
+}
+
+// merge merges the spilled runs and the emails in memory, writing them
+// to the next Sink, and closes every spilled run it opens
+func (s *SortingSink) merge() error {
+	s.emails.SortByDate()
+
+	all := []*sortRun{}
+	defer func() {
+		for _, r := range all {
+			r.close()
+		}
+	}()
+	for i, p := range s.spills {
+		r, err := openSpill(p, i)
+		if err != nil {
+			return err
+		}
+		all = append(all, r)
+	}
+	all = append(all, memoryRun(s.emails, len(s.spills)))
+
+	// advance sets the head of a run, closing it when exhausted
+	advance := func(r *sortRun) (bool, error) {
+		e, err := r.next()
+		if errors.Is(err, io.EOF) {
+			r.close()
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("sort spill decoding error, %w", err)
+		}
+		r.head = e
+		return true, nil
+	}
+	runs := sortRuns{}
+	for _, r := range all {
+		ok, err := advance(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			runs = append(runs, r)
+		}
+	}
+	heap.Init(&runs)
+	for runs.Len() > 0 {
+		r := runs[0]
+		if err := s.next.Write(r.head); err != nil {
+			return err
+		}
+		ok, err := advance(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&runs, 0)
+		} else {
+			heap.Pop(&runs)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"errors"
+	"net/mail"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+// recordingSink is a Sink recording the emails written to it, or
+// failing with err if set
+type recordingSink struct {
+	emails Emails
+	closed bool
+	err    error
+}
+
+func (r *recordingSink) Write(e EmailWithSource) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.emails = append(r.emails, e)
+	return nil
+}
+
+func (r *recordingSink) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSortingSink(t *testing.T) {
+	start := time.Date(2023, 8, 1, 9, 0, 0, 0, time.UTC)
+	// dates out of order, with some the same
+	hours := []int{5, 3, 9, 0, 3, 7, 1, 8, 3, 2, 6, 4, 0}
+	emails := Emails{}
+	for i, h := range hours {
+		e := EmailWithSource{
+			Headers: email.Headers{
+				Date:      start.Add(time.Duration(h) * time.Hour),
+				MessageID: string(rune('a' + i)),
+				From:      []*mail.Address{{Name: "Alice", Address: "alice@example.com"}},
+			},
+			source:      "alice.mbox",
+			attachments: []Attachment{{Name: "a.pdf", Size: int64(i), SHA256: "ab"}},
+		}
+		e.AddTag("custodian:alice")
+		e.setExtra(messageClassColumn, "personal")
+		emails = append(emails, e)
+	}
+	want := append(Emails{}, emails...)
+	want.SortByDate()
+
+	for _, limit := range []int{1, 4, 13, 100} {
+		dir := t.TempDir()
+		got := &recordingSink{}
+		s := NewSortingSink(got, limit, dir)
+		for _, e := range emails {
+			if err := s.Write(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !got.closed {
+			t.Error("next sink not closed")
+		}
+		if len(got.emails) != len(want) {
+			t.Fatalf("limit %d got %d emails want %d", limit, len(got.emails), len(want))
+		}
+		for i, e := range got.emails {
+			if e.MessageID != want[i].MessageID || !e.Date.Equal(want[i].Date) {
+				t.Errorf("limit %d email %d got %s %s want %s %s", limit, i, e.MessageID, e.Date, want[i].MessageID, want[i].Date)
+			}
+			if !e.HasTag("custodian:alice") || e.extra[TagsColumn] != "custodian:alice" {
+				t.Errorf("limit %d email %d tags not kept: %v", limit, i, e.Tags())
+			}
+			if e.extra[messageClassColumn] != "personal" || e.senderAddress() != "alice@example.com" || e.source != "alice.mbox" {
+				t.Errorf("limit %d email %d fields not kept", limit, i)
+			}
+			if len(e.attachments) != 1 || e.attachments[0].Size != want[i].attachments[0].Size {
+				t.Errorf("limit %d email %d attachments not kept: %v", limit, i, e.attachments)
+			}
+		}
+		left, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != 0 {
+			t.Errorf("limit %d left %d temporary files", limit, len(left))
+		}
+	}
+}
+
+// TestSortingSinkCloseError checks that the next sink is closed and the
+// spill files removed when merging fails
+func TestSortingSinkCloseError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	tests := []struct {
+		name    string
+		next    *recordingSink
+		missing bool // remove the second spill file before closing
+		want    error
+	}{
+		{"write", &recordingSink{err: errWrite}, false, errWrite},
+		{"open", &recordingSink{}, true, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := NewSortingSink(tt.next, 2, dir)
+			for i := range 5 {
+				e := EmailWithSource{Headers: email.Headers{Date: time.Date(2023, 8, 1, i, 0, 0, 0, time.UTC)}}
+				if err := s.Write(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.missing {
+				if err := os.Remove(s.spills[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Close(); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v want %v", err, tt.want)
+			}
+			if !tt.next.closed {
+				t.Error("next sink not closed")
+			}
+			left, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 0 {
+				t.Errorf("left %d temporary files", len(left))
+			}
+		})
+	}
+}
+
+func TestSortingSinkSpillError(t *testing.T) {
+	s := NewSortingSink(&recordingSink{}, 1, "testdata/missing-directory")
+	err := s.Write(EmailWithSource{})
+	if err == nil {
+		t.Fatal("expected spill error")
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error %s", err)
+	}
+}
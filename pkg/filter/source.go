@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"io"
+	"os"
+)
+
+// Source is a source of emails in mbox format, such as an mbox file.
+// Name identifies the source in reports.
+type Source interface {
+	Name() string
+	Open() (io.ReadCloser, error)
+}
+
+// Sizer is implemented by sources which know their size in bytes, such
+// as mbox files, for reporting progress
+type Sizer interface {
+	Size() (int64, error)
+}
+
+// MboxFile is a Source reading an mbox file at Path
+type MboxFile struct {
+	Path string
+}
+
+// Name returns the path of the mbox file
+func (m MboxFile) Name() string {
+	return m.Path
+}
+
+// Open opens the mbox file for reading
+func (m MboxFile) Open() (io.ReadCloser, error) {
+	return os.Open(m.Path)
+}
+
+// Size returns the size of the mbox file
+func (m MboxFile) Size() (int64, error) {
+	fi, err := os.Stat(m.Path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// NewMboxFiles returns a Source for each mbox file path
+func NewMboxFiles(paths ...string) []Source {
+	sources := make([]Source, len(paths))
+	for i, p := range paths {
+		sources[i] = MboxFile{Path: p}
+	}
+	return sources
+}
@@ -0,0 +1,232 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SourceStats are the statistics of processing a Source. Emails counts
+// the emails read and filtered, of which Accepted were accepted and the
+// remainder rejected by the filters named in Rejected. BytesRead is the
+// size of the source read so far. StoppedOnError is set if processing
+// of the source stopped on an error reading, parsing or filtering one of
+// its emails, and in the totals if any source stopped. Done is set once
+// processing of the source has finished. Earliest and Latest are the dates, in UTC, of
+// the earliest and latest emails read with a date.
+type SourceStats struct {
+	Name           string         `json:"name"`
+	Emails         int            `json:"emails"`
+	Accepted       int            `json:"accepted"`
+	Rejected       map[string]int `json:"rejected"` // by filter name
+	BytesRead      int64          `json:"bytesRead"`
+	StoppedOnError bool           `json:"stoppedOnError"`
+	Done           bool           `json:"done"`
+	Earliest       time.Time      `json:"earliest,omitzero"`
+	Latest         time.Time      `json:"latest,omitzero"`
+}
+
+// addEmail counts an email accepted, if rejectedBy is empty, or
+// otherwise rejected by the filter rejectedBy
+func (s *SourceStats) addEmail(date time.Time, rejectedBy string) {
+	s.Emails++
+	if rejectedBy == "" {
+		s.Accepted++
+	} else {
+		s.Rejected[rejectedBy]++
+	}
+	if date.IsZero() {
+		return
+	}
+	date = date.UTC()
+	if s.Earliest.IsZero() || date.Before(s.Earliest) {
+		s.Earliest = date
+	}
+	if s.Latest.IsZero() || date.After(s.Latest) {
+		s.Latest = date
+	}
+}
+
+// ProcessingStats are the statistics of processing a set of sources,
+// with totals over all sources followed by the statistics of each
+// source, and the number of accepted emails with each tag.
+type ProcessingStats struct {
+	Start             time.Time      `json:"start"`
+	End               time.Time      `json:"end"` // time of the last email or source processed
+	ElapsedSeconds    float64        `json:"elapsedSeconds"`
+	MessagesPerSecond float64        `json:"messagesPerSecond"`
+	SourcesDone       int            `json:"sourcesDone"` // sources processed
+	Tags              map[string]int `json:"tags"`
+	Total             SourceStats    `json:"total"`   // totals over all sources
+	Sources           []SourceStats  `json:"sources"` // sorted by name
+}
+
+// newSourceStats makes an empty SourceStats
+func newSourceStats(name string) *SourceStats {
+	return &SourceStats{Name: name, Rejected: map[string]int{}}
+}
+
+// sourceStats returns the stats of a source, adding them if
+// necessary. The caller should hold the stats lock.
+func (f *Filters) sourceStats(name string) *SourceStats {
+	s, ok := f.sources[name]
+	if !ok {
+		s = newSourceStats(name)
+		f.sources[name] = s
+	}
+	return s
+}
+
+// recordRead records bytes read from a source, as they are read
+func (f *Filters) recordRead(name string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sourceStats(name).BytesRead += int64(n)
+}
+
+// recordSource records whether processing of a source stopped on an
+// error once it has been processed
+func (f *Filters) recordSource(name string, stoppedOnError bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.sourceStats(name)
+	s.StoppedOnError = s.StoppedOnError || stoppedOnError
+	s.Done = true
+	f.done++
+	f.last = time.Now()
+}
+
+// statsReader is a reader recording the bytes read from a source in
+// the stats of the Filters
+type statsReader struct {
+	r       io.Reader
+	name    string
+	filters *Filters
+}
+
+func (s statsReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.filters.recordRead(s.name, n)
+	}
+	return n, err
+}
+
+// ProcessingStats returns the statistics of processing, which may be
+// called while emails are being filtered.
+func (f *Filters) ProcessingStats() ProcessingStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.last
+	if end.IsZero() {
+		end = time.Now()
+	}
+	p := ProcessingStats{
+		Start:          f.start,
+		End:            end,
+		ElapsedSeconds: end.Sub(f.start).Seconds(),
+		SourcesDone:    f.done,
+		Tags:           maps.Clone(f.tagStats),
+		Total:          *newSourceStats("total"),
+		Sources:        []SourceStats{},
+	}
+	p.Total.Done = true
+	for _, s := range f.sources {
+		c := *s
+		c.Rejected = maps.Clone(s.Rejected)
+		p.Sources = append(p.Sources, c)
+
+		t := &p.Total
+		t.Emails += s.Emails
+		t.Accepted += s.Accepted
+		for k, v := range s.Rejected {
+			t.Rejected[k] += v
+		}
+		t.BytesRead += s.BytesRead
+		t.StoppedOnError = t.StoppedOnError || s.StoppedOnError
+		t.Done = t.Done && s.Done
+		if !s.Earliest.IsZero() && (t.Earliest.IsZero() || s.Earliest.Before(t.Earliest)) {
+			t.Earliest = s.Earliest
+		}
+		if s.Latest.After(t.Latest) {
+			t.Latest = s.Latest
+		}
+	}
+	sort.Slice(p.Sources, func(i, j int) bool {
+		return p.Sources[i].Name < p.Sources[j].Name
+	})
+	if p.ElapsedSeconds > 0 {
+		p.MessagesPerSecond = float64(p.Total.Emails) / p.ElapsedSeconds
+	}
+	return p
+}
+
+// WriteJSON writes the stats as indented json
+func (p ProcessingStats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return fmt.Errorf("stats json error, %w", err)
+	}
+	return nil
+}
+
+// formatStatsDate formats an earliest or latest date in the timezone
+// loc, or "-" if there is none
+func formatStatsDate(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.In(loc).Format("2006-01-02 15:04")
+}
+
+// WriteTable writes the stats as text tables of the totals and each
+// source, followed by the rejections of each filter by source, showing
+// dates in the timezone loc
+func (p ProcessingStats) WriteTable(w io.Writer, loc *time.Location) error {
+	rows := append(append([]SourceStats{}, p.Sources...), p.Total)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "source\temails\taccepted\trejected\tbytes\tstopped on error\tearliest\tlatest\t")
+	for _, s := range rows {
+		stopped := "no"
+		if s.StoppedOnError {
+			stopped = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n",
+			s.Name, s.Emails, s.Accepted, s.Emails-s.Accepted, s.BytesRead, stopped,
+			formatStatsDate(s.Earliest, loc), formatStatsDate(s.Latest, loc))
+	}
+	fmt.Fprintln(tw)
+
+	filters := []string{}
+	for k := range p.Total.Rejected {
+		filters = append(filters, k)
+	}
+	sort.Strings(filters)
+	if len(filters) > 0 {
+		header := []string{"rejected by"}
+		for _, s := range p.Sources {
+			header = append(header, s.Name)
+		}
+		fmt.Fprintln(tw, strings.Join(append(header, "total"), "\t")+"\t")
+		for _, k := range filters {
+			row := []string{k}
+			for _, s := range rows {
+				row = append(row, fmt.Sprint(s.Rejected[k]))
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "elapsed\t%.3fs\t\n", p.ElapsedSeconds)
+	fmt.Fprintf(tw, "messages/second\t%.1f\t\n", p.MessagesPerSecond)
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("stats table error, %w", err)
+	}
+	return nil
+}
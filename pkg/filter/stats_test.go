@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessingStats(t *testing.T) {
+	config := testConfig(t, "")
+	paths := []string{"testdata/golang.mbox", "testdata/gonuts.mbox"}
+	p := testPipeline(t, config, NewMboxFiles(paths...)...)
+	p.AddFilters(subjectFilter("is released"))
+	testCollect(t, p)
+
+	stats := p.Filters.ProcessingStats()
+	if got, want := len(stats.Sources), 2; got != want {
+		t.Fatalf("got %d sources want %d", got, want)
+	}
+	var size int64
+	for i, s := range stats.Sources {
+		if got, want := s.Name, paths[i]; got != want {
+			t.Errorf("got source %s want %s", got, want)
+		}
+		fi, err := os.Stat(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s.BytesRead, fi.Size(); got != want {
+			t.Errorf("%s got %d bytes read want %d", s.Name, got, want)
+		}
+		size += fi.Size()
+	}
+	golang := stats.Sources[0]
+	if golang.Emails != 2 || golang.Accepted != 1 || golang.Rejected["subject"] != 1 {
+		t.Errorf("unexpected golang.mbox stats %+v", golang)
+	}
+	total := stats.Total
+	if total.Emails != 3 || total.Accepted != 1 || total.Rejected["subject"] != 2 || total.BytesRead != size {
+		t.Errorf("unexpected total stats %+v", total)
+	}
+	if got, want := total.Earliest, time.Date(2023, 8, 8, 15, 22, 4, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got earliest %s want %s", got, want)
+	}
+	if !total.Latest.Equal(golang.Latest) || !total.Latest.After(total.Earliest) {
+		t.Errorf("unexpected latest %s", total.Latest)
+	}
+	if got, want := stats.SourcesDone, 2; got != want {
+		t.Errorf("got %d sources done want %d", got, want)
+	}
+	if stats.ElapsedSeconds <= 0 || stats.MessagesPerSecond <= 0 {
+		t.Errorf("unexpected rate %f over %fs", stats.MessagesPerSecond, stats.ElapsedSeconds)
+	}
+
+	var buf bytes.Buffer
+	if err := stats.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ProcessingStats
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total.Emails != 3 || len(decoded.Sources) != 2 || !decoded.Total.Earliest.Equal(total.Earliest) {
+		t.Errorf("unexpected decoded stats %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := stats.WriteTable(&buf, config.Location); err != nil {
+		t.Fatal(err)
+	}
+	table := buf.String()
+	for _, want := range []string{"testdata/gonuts.mbox", "rejected by", "subject", "2023-08-08 15:22", "messages/second"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("table missing %q\n%s", want, table)
+		}
+	}
+}
+
+func TestProcessingStatsParseError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.mbox")
+	if err := os.WriteFile(path, []byte("not an mbox\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := testPipeline(t, testConfig(t, ""), NewMboxFiles(path)...)
+	if _, err := p.Collect(context.Background()); err == nil {
+		t.Fatal("expected processing error")
+	}
+	stats := p.Filters.ProcessingStats()
+	if !stats.Total.StoppedOnError || !stats.Sources[0].StoppedOnError {
+		t.Error("expected processing to have stopped on an error")
+	}
+	if got, want := stats.Total.Emails, 0; got != want {
+		t.Errorf("got %d emails want %d", got, want)
+	}
+}
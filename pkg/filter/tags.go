@@ -0,0 +1,92 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TagRule describes a rule tagging emails for which an expression, as
+// used by an ExpressionFilter, is true with Tag, such as
+// "custodian:alice", without excluding any emails.
+type TagRule struct {
+	Tag        string
+	Expression ExpressionFilter
+}
+
+func (t TagRule) String() string {
+	return fmt.Sprintf("%s (%s)", t.Tag, t.Expression.Expression)
+}
+
+// NewTagRule makes a TagRule, compiling the expression as described by
+// NewExpressionFilter.
+func NewTagRule(tag, expression string, loc *time.Location) (TagRule, error) {
+	x, err := NewExpressionFilter("tag "+tag, expression, loc)
+	if err != nil {
+		return TagRule{}, err
+	}
+	return TagRule{Tag: tag, Expression: *x}, nil
+}
+
+// tagger is the Filter for a TagRule, which accepts all emails
+type tagger struct {
+	rule TagRule
+}
+
+// newTagger tags emails as described by a TagRule. An expression which
+// fails to run reports an error.
+func newTagger(t TagRule) Filter {
+	return tagger{rule: t}
+}
+
+// Name returns the name of the filter
+func (t tagger) Name() string {
+	return t.rule.Expression.Name
+}
+
+// Describe describes the filter
+func (t tagger) Describe() string {
+	return t.rule.String()
+}
+
+// Evaluate tags the email if the expression is true, accepting it
+// regardless
+func (t tagger) Evaluate(ctx context.Context, e *EmailWithSource) (Decision, error) {
+	d, err := newFilterByExpression(t.rule.Expression).Evaluate(ctx, e)
+	if err != nil {
+		return Reject, err
+	}
+	if d == Accept {
+		e.AddTag(t.rule.Tag)
+	}
+	return Accept, nil
+}
+
+// Tags returns the sorted tags of the emails
+func (e Emails) Tags() []string {
+	seen := map[string]struct{}{}
+	for _, em := range e {
+		for _, t := range em.tags {
+			seen[t] = struct{}{}
+		}
+	}
+	tags := []string{}
+	for t := range seen {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// WithTag returns the emails with a tag, for example to write a report
+// for each tag
+func (e Emails) WithTag(tag string) Emails {
+	tagged := NewEmails()
+	for _, em := range e {
+		if em.HasTag(tag) {
+			tagged.Add(em)
+		}
+	}
+	return tagged
+}
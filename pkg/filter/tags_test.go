@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/letters/email"
+)
+
+func TestTagRules(t *testing.T) {
+	rules := []struct {
+		tag        string
+		expression string
+	}{
+		{"custodian:alice", `"alice@example.com" in from`},
+		{"custodian:bob", `"bob@example.com" in from`},
+		{"privileged", `subject matches "(?i)privileged"`},
+		{"reply", `len(inReplyTo) > 0`},
+		// a rule may use the tags of earlier rules
+		{"alice-privileged", `"custodian:alice" in tags and "privileged" in tags`},
+	}
+	var filters []Filter
+	for _, r := range rules {
+		tr, err := NewTagRule(r.tag, r.expression, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, newTagger(tr))
+	}
+	filters = append(filters, newFilter("no bob", func(e EmailWithSource) bool {
+		return !e.HasTag("custodian:bob")
+	}))
+	f := NewFilters(filters...)
+
+	tests := []struct {
+		from    string
+		subject string
+		ok      bool
+		tags    string
+	}{
+		{"alice@example.com", "Privileged and confidential", true, "custodian:alice; privileged; alice-privileged"},
+		{"alice@example.com", "lunch", true, "custodian:alice"},
+		{"carol@example.com", "lunch", true, ""},
+		{"bob@example.com", "privileged", false, "custodian:bob; privileged"},
+	}
+
+	emails := NewEmails()
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{
+			Subject:   tt.subject,
+			From:      []*mail.Address{{Address: tt.from}},
+			MessageID: fmt.Sprintf("%d@example.com", i),
+		}}
+		ok, err := f.Filter(context.Background(), &e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Errorf("%d got %t want %t", i, ok, tt.ok)
+		}
+		if got, want := strings.Join(e.Tags(), "; "), tt.tags; got != want {
+			t.Errorf("%d got tags %q want %q", i, got, want)
+		}
+		if got, want := e.Extra(TagsColumn), tt.tags; got != want {
+			t.Errorf("%d got tags column %q want %q", i, got, want)
+		}
+		emails.Add(e)
+	}
+
+	if got, want := fmt.Sprint(emails.Tags()), "[alice-privileged custodian:alice custodian:bob privileged]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := len(emails.WithTag("custodian:alice")), 2; got != want {
+		t.Errorf("got %d want %d emails tagged custodian:alice", got, want)
+	}
+
+	// only the tags of accepted emails are counted, which are recorded
+	// by Filter before it returns
+	if got, want := f.ProcessingStats().Tags, map[string]int{"alice-privileged": 1, "custodian:alice": 2, "privileged": 1}; !maps.Equal(got, want) {
+		t.Errorf("got tag stats %v want %v", got, want)
+	}
+	stats := f.Stats()
+	for _, want := range []string{
+		fmt.Sprintf("%-30s: %4d\n", "custodian:alice", 2),
+		fmt.Sprintf("%-30s: %4d\n", "privileged", 1),
+	} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("stats missing %q\n%s", want, stats)
+		}
+	}
+	if strings.Contains(stats, "custodian:bob") {
+		t.Errorf("stats unexpectedly contain custodian:bob\n%s", stats)
+	}
+}
+
+func TestAddTag(t *testing.T) {
+	var e EmailWithSource
+	for _, tag := range []string{"a", "", "b", "a"} {
+		e.AddTag(tag)
+	}
+	if got, want := fmt.Sprint(e.Tags()), "[a b]"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if !e.HasTag("b") || e.HasTag("c") {
+		t.Errorf("unexpected HasTag results for %v", e.Tags())
+	}
+}
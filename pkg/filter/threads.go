@@ -0,0 +1,332 @@
+package filter
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Threading reconstructs email conversations from the Message-ID,
+// In-Reply-To and References headers of a set of emails, following
+// Jamie Zawinski's algorithm described at
+// https://www.jwz.org/doc/threading.html. Messages whose references
+// have been lost are grouped by their normalised subject.
+
+// threadColumns are the optional report columns set by threading
+var threadColumns = []string{"thread", "depth", "parent"}
+
+// threadExcludedColumn is the optional report column recording the
+// filter which rejected an email included only by virtue of its thread
+const threadExcludedColumn = "excluded by"
+
+// subjectPrefixRegexp matches reply and forward prefixes at the start of
+// a subject, such as "Re: Fwd: Re[2]:"
+var subjectPrefixRegexp = regexp.MustCompile(`(?i)^\s*((re|fw|fwd|aw|wg|sv|vs)(\[\d+\])?\s*:\s*)+`)
+
+// normaliseSubject returns a subject stripped of reply and forward
+// prefixes, folded to lower case with whitespace collapsed, together
+// with whether any prefix was found.
+func normaliseSubject(s string) (string, bool) {
+	stripped := subjectPrefixRegexp.ReplaceAllString(s, "")
+	isReply := stripped != s
+	return strings.ToLower(strings.Join(strings.Fields(stripped), " ")), isReply
+}
+
+// container is a node in a thread tree. A container without an email
+// represents a message referred to by other messages but not itself
+// present in the mboxes.
+type container struct {
+	id       string
+	email    *EmailWithSource
+	parent   *container
+	children []*container
+}
+
+// isAncestorOf reports if c is d or one of d's ancestors
+func (c *container) isAncestorOf(d *container) bool {
+	for p := d; p != nil; p = p.parent {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
+// setParent moves c from any existing parent to be a child of p
+func (c *container) setParent(p *container) {
+	if c.parent != nil {
+		siblings := c.parent.children
+		for i, s := range siblings {
+			if s == c {
+				c.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	c.parent = p
+	if p != nil {
+		p.children = append(p.children, c)
+	}
+}
+
+// subject returns the subject of the container's email or, for an
+// empty container, that of its first child with an email
+func (c *container) subject() string {
+	if c.email != nil {
+		return c.email.Subject
+	}
+	for _, child := range c.children {
+		if s := child.subject(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// threadID returns the message id identifying the thread rooted at c
+func (c *container) threadID() string {
+	if c.id != "" {
+		return c.id
+	}
+	for _, child := range c.children {
+		if id := child.threadID(); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// references returns the ancestry of an email from its References
+// header, completed by the first In-Reply-To message id if that is not
+// already the last reference.
+func references(e *EmailWithSource) []string {
+	refs := append([]string{}, e.References...)
+	if len(e.InReplyTo) > 0 {
+		if irt := e.InReplyTo[0]; len(refs) == 0 || refs[len(refs)-1] != irt {
+			refs = append(refs, irt)
+		}
+	}
+	return refs
+}
+
+// pruneContainers removes empty containers without children and
+// promotes the children of other empty containers to their parent,
+// except at the root where an empty container holding several children
+// is kept to hold the thread together.
+func pruneContainers(containers []*container, atRoot bool) []*container {
+	pruned := []*container{}
+	for _, c := range containers {
+		c.children = pruneContainers(c.children, false)
+		if c.email != nil {
+			pruned = append(pruned, c)
+			continue
+		}
+		if len(c.children) == 0 {
+			continue
+		}
+		if atRoot && len(c.children) > 1 {
+			pruned = append(pruned, c)
+			continue
+		}
+		for _, child := range c.children {
+			child.parent = c.parent
+			pruned = append(pruned, child)
+		}
+	}
+	return pruned
+}
+
+// groupBySubject merges root containers sharing a normalised subject,
+// placing replies below the original message where that can be
+// determined.
+func groupBySubject(roots []*container) []*container {
+	bySubject := map[string]*container{}
+	grouped := []*container{}
+	for _, r := range roots {
+		subject, isReply := normaliseSubject(r.subject())
+		if subject == "" {
+			grouped = append(grouped, r)
+			continue
+		}
+		existing, ok := bySubject[subject]
+		if !ok {
+			bySubject[subject] = r
+			grouped = append(grouped, r)
+			continue
+		}
+		_, existingIsReply := normaliseSubject(existing.subject())
+		switch {
+		case existing.email == nil:
+			r.setParent(existing)
+		case isReply && !existingIsReply:
+			r.setParent(existing)
+		default:
+			// neither is clearly the original so hold both in a new
+			// empty container
+			holder := &container{}
+			for i, g := range grouped {
+				if g == existing {
+					grouped[i] = holder
+				}
+			}
+			existing.setParent(holder)
+			r.setParent(holder)
+			bySubject[subject] = holder
+		}
+	}
+	return grouped
+}
+
+// Threads reconstructs the threads of the emails, setting the thread,
+// depth and parent report columns of each email. The returned emails
+// are those accepted by the filters together with, if wholeThreads is
+// true, any rejected emails belonging to a thread containing an
+// accepted email. Rejected emails included in this way have the name of
+// the rejecting filter recorded in the threadExcludedColumn. The
+// returned emails are sorted by date, then source and message id, and
+// are copies, so that the emails of e are not changed.
+func (e Emails) Threads(wholeThreads bool) Emails {
+
+	// build threads from the emails in a stable order, as the order in
+	// which emails are processed varies between runs, and decides which
+	// copy of a duplicate message id is threaded by id and the thread id
+	// of emails grouped by subject. The report columns of the copies
+	// are cloned as they are set below.
+	e = slices.Clone(e)
+	for i := range e {
+		e[i].extra = maps.Clone(e[i].extra)
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		a, b := e[i], e[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.MessageID < b.MessageID
+	})
+
+	idTable := map[string]*container{}
+	all := []*container{}
+
+	getContainer := func(id string) *container {
+		if c, ok := idTable[id]; ok {
+			return c
+		}
+		c := &container{id: id}
+		idTable[id] = c
+		all = append(all, c)
+		return c
+	}
+
+	for i := range e {
+		em := &e[i]
+
+		// emails with no or a duplicate message id are given their own
+		// container outside of the id table
+		var c *container
+		if em.MessageID != "" {
+			c = getContainer(em.MessageID)
+		}
+		if c == nil || c.email != nil {
+			c = &container{}
+			all = append(all, c)
+		}
+		c.email = em
+
+		// link the references in order, without overriding links
+		// already made or introducing loops
+		var prev *container
+		for _, ref := range references(em) {
+			rc := getContainer(ref)
+			if prev != nil && rc.parent == nil && !rc.isAncestorOf(prev) {
+				rc.setParent(prev)
+			}
+			prev = rc
+		}
+
+		// the email's parent is its last reference, which overrides
+		// any link made by the references of other emails
+		if prev != nil && c.isAncestorOf(prev) {
+			prev = nil
+		}
+		c.setParent(prev)
+	}
+
+	roots := []*container{}
+	for _, c := range all {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+	roots = groupBySubject(pruneContainers(roots, true))
+
+	// set the thread columns
+	unidentified := 0
+	var walk func(c *container, threadID string, depth int)
+	walk = func(c *container, threadID string, depth int) {
+		if c.email != nil {
+			// the parent is the message replied to, whether or not
+			// it is present in the mboxes
+			parentID := ""
+			if refs := references(c.email); len(refs) > 0 {
+				parentID = refs[len(refs)-1]
+			}
+			c.email.setExtra(threadColumns[0], threadID)
+			c.email.setExtra(threadColumns[1], strconv.Itoa(depth))
+			c.email.setExtra(threadColumns[2], parentID)
+		}
+		for _, child := range c.children {
+			walk(child, threadID, depth+1)
+		}
+	}
+	for _, r := range roots {
+		threadID := r.threadID()
+		if threadID == "" {
+			unidentified++
+			threadID = fmt.Sprintf("unidentified-%d", unidentified)
+		}
+		depth := 0
+		if r.email == nil && r.id == "" {
+			depth = -1 // the children of a subject group are at depth 0
+		}
+		walk(r, threadID, depth)
+	}
+
+	if !wholeThreads {
+		return e.Accepted()
+	}
+
+	// include the rejected emails of threads with an accepted email,
+	// skipping duplicate message ids
+	acceptedThreads := map[string]bool{}
+	seenIDs := map[string]bool{}
+	for _, em := range e {
+		if em.rejected == "" {
+			acceptedThreads[em.extra[threadColumns[0]]] = true
+			seenIDs[em.MessageID] = true
+		}
+	}
+	threaded := NewEmails()
+	for _, em := range e {
+		if em.rejected == "" {
+			threaded.Add(em)
+			continue
+		}
+		if !acceptedThreads[em.extra[threadColumns[0]]] {
+			continue
+		}
+		if em.MessageID != "" && seenIDs[em.MessageID] {
+			continue
+		}
+		seenIDs[em.MessageID] = true
+		em.setExtra(threadExcludedColumn, em.rejected)
+		threaded.Add(em)
+	}
+	return threaded
+}
@@ -0,0 +1,167 @@
+package filter
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rorycl/letters/email"
+)
+
+func TestNormaliseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+		isReply bool
+	}{
+		{"Planning application", "planning application", false},
+		{"Re: Planning application", "planning application", true},
+		{"RE: Fwd:  Planning   application", "planning application", true},
+		{"Re[2]: planning application", "planning application", true},
+		{"Regarding planning", "regarding planning", false},
+		{"", "", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got, isReply := normaliseSubject(tt.subject)
+			if got != tt.want || isReply != tt.isReply {
+				t.Errorf("got %q %t want %q %t", got, isReply, tt.want, tt.isReply)
+			}
+		})
+	}
+}
+
+// threadEmail makes an EmailWithSource for threading tests
+func threadEmail(id, subject, rejected string, day int, refs ...string) EmailWithSource {
+	e := EmailWithSource{Headers: email.Headers{}, source: "test", rejected: rejected}
+	e.MessageID = id
+	e.Subject = subject
+	e.Date = time.Date(2022, 3, day, 0, 0, 0, 0, time.UTC)
+	e.References = refs
+	return e
+}
+
+func TestThreads(t *testing.T) {
+
+	emails := Emails{
+		threadEmail("a", "planning", "", 1),
+		threadEmail("b", "Re: planning", "", 2, "a"),
+		threadEmail("c", "Re: planning", "", 3, "a", "b"),
+		// reply to a message not in the mbox
+		threadEmail("d", "Re: budget", "", 4, "missing"),
+		threadEmail("e", "Re: budget", "", 5, "missing"),
+		// references lost, threaded by subject
+		threadEmail("f", "drawings", "", 6),
+		threadEmail("g", "Re: drawings", "", 7),
+		// rejected email in an accepted thread
+		threadEmail("h", "Re: planning", "on holiday", 8, "a", "b", "c"),
+		// rejected thread
+		threadEmail("i", "other", "on holiday", 9),
+	}
+
+	type row struct {
+		id, thread, depth, parent, excluded string
+	}
+	rows := func(emails Emails) []row {
+		r := []row{}
+		for _, e := range emails {
+			r = append(r, row{e.MessageID, e.extra["thread"], e.extra["depth"], e.extra["parent"], e.extra[threadExcludedColumn]})
+		}
+		return r
+	}
+
+	got := rows(emails.Threads(false))
+	want := []row{
+		{"a", "a", "0", "", ""},
+		{"b", "a", "1", "a", ""},
+		{"c", "a", "2", "b", ""},
+		{"d", "missing", "1", "missing", ""},
+		{"e", "missing", "1", "missing", ""},
+		{"f", "f", "0", "", ""},
+		{"g", "f", "1", "", ""},
+	}
+	if !cmp.Equal(got, want, cmp.AllowUnexported(row{})) {
+		t.Errorf("threads unexpected %s", cmp.Diff(got, want, cmp.AllowUnexported(row{})))
+	}
+
+	got = rows(emails.Threads(true))
+	want = append(want, row{"h", "a", "3", "c", "on holiday"})
+	if !cmp.Equal(got, want, cmp.AllowUnexported(row{})) {
+		t.Errorf("whole threads unexpected %s", cmp.Diff(got, want, cmp.AllowUnexported(row{})))
+	}
+}
+
+func TestThreadsLoop(t *testing.T) {
+	// references which contradict each other should not cause a loop
+	emails := Emails{
+		threadEmail("a", "x", "", 1, "b"),
+		threadEmail("b", "x", "", 2, "a"),
+	}
+	threaded := emails.Threads(false)
+	if got, want := len(threaded), 2; got != want {
+		t.Fatalf("got %d want %d emails", got, want)
+	}
+	if threaded[0].extra["thread"] != threaded[1].extra["thread"] {
+		t.Errorf("expected emails to share a thread, got %q and %q",
+			threaded[0].extra["thread"], threaded[1].extra["thread"])
+	}
+}
+
+func TestThreadsOrder(t *testing.T) {
+	fromSource := func(e EmailWithSource, source string) EmailWithSource {
+		e.source = source
+		return e
+	}
+	emails := Emails{
+		threadEmail("a", "planning", "", 1),
+		threadEmail("b", "Re: planning", "", 2, "a"),
+		// a duplicate message id in another mbox, with other references
+		fromSource(threadEmail("b", "Re: planning", "", 2, "x"), "other"),
+		// originals with the same subject, held together by subject, at
+		// the same time in different mboxes
+		fromSource(threadEmail("f", "drawings", "", 6), "one"),
+		fromSource(threadEmail("g", "drawings", "", 6), "two"),
+		threadEmail("", "no id", "", 7),
+		threadEmail("", "no id either", "", 7),
+	}
+	for i := range emails {
+		emails[i].setExtra(messageClassColumn, "human")
+	}
+
+	type row struct {
+		id, source, thread, depth, parent string
+	}
+	rows := func(emails Emails) []row {
+		r := []row{}
+		for _, e := range emails {
+			r = append(r, row{e.MessageID, e.source, e.extra["thread"], e.extra["depth"], e.extra["parent"]})
+		}
+		sort.Slice(r, func(i, j int) bool {
+			return fmt.Sprint(r[i]) < fmt.Sprint(r[j])
+		})
+		return r
+	}
+
+	want := rows(emails.Threads(false))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 50 {
+		shuffled := make(Emails, len(emails))
+		for j, k := range rng.Perm(len(emails)) {
+			shuffled[j] = emails[k]
+		}
+		got := rows(shuffled.Threads(false))
+		if !cmp.Equal(got, want, cmp.AllowUnexported(row{})) {
+			t.Fatalf("shuffle %d threads differ %s", i, cmp.Diff(want, got, cmp.AllowUnexported(row{})))
+		}
+	}
+
+	// the columns of the emails threaded are not changed
+	for _, e := range emails {
+		if got, want := e.extra, map[string]string{messageClassColumn: "human"}; !cmp.Equal(got, want) {
+			t.Errorf("email %q columns changed to %v", e.MessageID, got)
+		}
+	}
+}
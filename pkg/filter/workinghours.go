@@ -0,0 +1,175 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// workingHoursColumn is the optional report column showing why an email
+// was sent outside of working hours
+const workingHoursColumn = "out of hours"
+
+// outOfHoursTag is the tag of emails sent outside of working hours
+const outOfHoursTag = "out-of-hours"
+
+// weekdayNames maps three letter day abbreviations to time.Weekday
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// workingPeriod is a period of work within a day, described by offsets
+// from midnight
+type workingPeriod struct {
+	start, end time.Duration
+}
+
+// WorkingHours describes a weekly working schedule together with
+// public holidays, evaluated in Location.
+type WorkingHours struct {
+	Location       *time.Location
+	Schedule       [7][]workingPeriod  // working periods indexed by time.Weekday
+	PublicHolidays map[string]struct{} // dates in 2006-01-02 format
+	Tag            bool                // tag out of hours emails rather than exclude them
+}
+
+// parseWeekdays parses a comma separated list of day abbreviations or
+// ranges of days, such as "Mon-Fri" or "Mon,Wed,Sat-Sun".
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	days := []time.Weekday{}
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		fromDay, ok := weekdayNames[strings.ToLower(strings.TrimSpace(from))]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q in %q", from, s)
+		}
+		if !isRange {
+			days = append(days, fromDay)
+			continue
+		}
+		toDay, ok := weekdayNames[strings.ToLower(strings.TrimSpace(to))]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q in %q", to, s)
+		}
+		for d := fromDay; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == toDay {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a 24 hour clock time such as "17:30" as an offset
+// from midnight. "24:00" is permitted to describe the end of a day.
+func parseClock(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid time %q, %w", s, err)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseWorkingPeriod parses a period such as "09:00-17:30"
+func parseWorkingPeriod(s string) (workingPeriod, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return workingPeriod{}, fmt.Errorf("invalid hours %q, expected a form such as 09:00-17:30", s)
+	}
+	var p workingPeriod
+	var err error
+	if p.start, err = parseClock(strings.TrimSpace(start)); err != nil {
+		return p, err
+	}
+	if p.end, err = parseClock(strings.TrimSpace(end)); err != nil {
+		return p, err
+	}
+	if p.end <= p.start {
+		return p, fmt.Errorf("hours %q end before they start", s)
+	}
+	return p, nil
+}
+
+// addSchedule adds working hours such as "09:00-17:30" to the days
+// described by days, such as "Mon-Fri".
+func (w *WorkingHours) addSchedule(days, hours string) error {
+	weekdays, err := parseWeekdays(days)
+	if err != nil {
+		return err
+	}
+	period, err := parseWorkingPeriod(hours)
+	if err != nil {
+		return err
+	}
+	for _, d := range weekdays {
+		w.Schedule[d] = append(w.Schedule[d], period)
+	}
+	return nil
+}
+
+// addPublicHolidays adds each date, in Location, on which any of
+// holidays falls as a public holiday. Holidays end exclusively, as read
+// from a calendar with inclusive set to false.
+func (w *WorkingHours) addPublicHolidays(holidays []Holiday) {
+	for _, h := range holidays {
+		start := h.Start.In(w.Location)
+		d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, w.Location)
+		for ; d.Before(h.End); d = d.AddDate(0, 0, 1) {
+			w.PublicHolidays[d.Format("2006-01-02")] = struct{}{}
+		}
+	}
+}
+
+// outOfHours reports why t is outside of working hours, or an empty
+// string if t is within working hours.
+func (w WorkingHours) outOfHours(t time.Time) string {
+	local := t.In(w.Location)
+	if _, ok := w.PublicHolidays[local.Format("2006-01-02")]; ok {
+		return "public holiday"
+	}
+	periods := w.Schedule[local.Weekday()]
+	if len(periods) == 0 {
+		return "non-working day"
+	}
+	// use the wall clock, which is unaffected by daylight saving changes
+	offset := time.Duration(local.Hour())*time.Hour +
+		time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second
+	for _, p := range periods {
+		if offset >= p.start && offset < p.end {
+			return ""
+		}
+	}
+	return "outside working hours"
+}
+
+// newFilterByWorkingHours filters out emails sent outside of working
+// hours
+func newFilterByWorkingHours(name string, w WorkingHours) Filter {
+	return newFilter(name, func(e EmailWithSource) bool {
+		return w.outOfHours(e.Date) == ""
+	})
+}
+
+// newWorkingHoursAnnotator records in the workingHoursColumn why an
+// email was sent outside of working hours, tagging it with
+// outOfHoursTag
+func newWorkingHoursAnnotator(w WorkingHours) annotatorFunc {
+	return func(e *EmailWithSource) {
+		reason := w.outOfHours(e.Date)
+		e.setExtra(workingHoursColumn, reason)
+		if reason != "" {
+			e.AddTag(outOfHoursTag)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rorycl/letters/email"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		days string
+		want []time.Weekday
+		err  bool
+	}{
+		{"Mon-Fri", []time.Weekday{1, 2, 3, 4, 5}, false},
+		{"sat, Sun", []time.Weekday{6, 0}, false},
+		{"Fri-Mon", []time.Weekday{5, 6, 0, 1}, false},
+		{"Mon,Wed-Thu", []time.Weekday{1, 3, 4}, false},
+		{"Monday", nil, true},
+		{"Mon-", nil, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got, err := parseWeekdays(tt.days)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, expected error %t", err, tt.err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkingPeriod(t *testing.T) {
+	tests := []struct {
+		hours string
+		err   bool
+	}{
+		{"09:00-17:30", false},
+		{"00:00-24:00", false},
+		{"17:30-09:00", true},
+		{"09:00", true},
+		{"09:00-24:30", true},
+		{"09:60-17:00", true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			_, err := parseWorkingPeriod(tt.hours)
+			if (err != nil) != tt.err {
+				t.Errorf("%s got error %v, expected error %t", tt.hours, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWorkingHours(t *testing.T) {
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := WorkingHours{
+		Location:       london,
+		PublicHolidays: map[string]struct{}{"2022-12-26": {}},
+	}
+	if err := w.addSchedule("Mon-Fri", "09:00-17:30"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.addSchedule("Sat", "10:00-12:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	nf := newFilterByWorkingHours("working hours filter", w)
+	annotate := newWorkingHoursAnnotator(w)
+
+	tests := []struct {
+		date   time.Time
+		reason string
+	}{
+		// Wednesday 13 July 2022 is in British Summer Time
+		{time.Date(2022, 7, 13, 8, 0, 0, 0, time.UTC), ""},
+		{time.Date(2022, 7, 13, 7, 59, 0, 0, time.UTC), "outside working hours"},
+		{time.Date(2022, 7, 13, 16, 29, 59, 0, time.UTC), ""},
+		{time.Date(2022, 7, 13, 16, 30, 0, 0, time.UTC), "outside working hours"},
+		{time.Date(2022, 7, 16, 10, 0, 0, 0, time.UTC), ""},
+		{time.Date(2022, 7, 16, 11, 0, 0, 0, time.UTC), "outside working hours"},
+		{time.Date(2022, 7, 17, 10, 0, 0, 0, time.UTC), "non-working day"},
+		{time.Date(2022, 12, 26, 10, 0, 0, 0, time.UTC), "public holiday"},
+		// 00:30 on a Monday in BST is Sunday in UTC
+		{time.Date(2022, 7, 17, 23, 30, 0, 0, time.UTC), "outside working hours"},
+	}
+
+	for i, tt := range tests {
+		e := EmailWithSource{Headers: email.Headers{}, source: "test"}
+		e.Date = tt.date
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if got, want := accepted(nf, e), tt.reason == ""; got != want {
+				t.Errorf("got %t != want %t for %s", got, want, tt.date)
+			}
+			annotate(&e)
+			if got, want := e.extra[workingHoursColumn], tt.reason; got != want {
+				t.Errorf("got %q != want %q for %s", got, want, tt.date)
+			}
+		})
+	}
+}